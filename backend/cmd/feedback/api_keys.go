@@ -0,0 +1,155 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package main
+
+import (
+	"errors"
+	"feedback/internal"
+	"feedback/internal/auth"
+	"feedback/internal/repository"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// apiKeys manages the API keys of the admin API, e.g. `feedback api-key create -name grafana -scopes read`.
+func apiKeys(conf *internal.Configuration, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: api-key create|list|revoke")
+	}
+
+	switch args[0] {
+	case "create":
+		return createApiKey(conf, args[1:])
+	case "list":
+		return listApiKeys(conf)
+	case "revoke":
+		return revokeApiKey(conf, args[1:])
+	}
+	return fmt.Errorf("unknown api-key command %s, expected create, list or revoke", args[0])
+}
+
+func createApiKey(conf *internal.Configuration, args []string) error {
+	flags := flag.NewFlagSet("api-key create", flag.ExitOnError)
+	name := flags.String("name", "", "who or what uses the key")
+	scopeList := flags.String("scopes", auth.ScopeRead, "comma separated scopes: read, export, delete, manage-surveys, manage-logging")
+	tenantId := flags.String("tenant", "", "restrict the key to the data of this tenant instead of the default tenant")
+	global := flags.Bool("global", false, "allow the key to act on every tenant")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *name == "" {
+		return errors.New("-name is required")
+	}
+	if *global && *tenantId != "" {
+		return errors.New("-global and -tenant exclude each other")
+	}
+	scopes, err := auth.ParseScopes(*scopeList)
+	if err != nil {
+		return err
+	}
+
+	key, err := auth.NewApiKey()
+	if err != nil {
+		return err
+	}
+	repo, err := repository.Open(conf)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+	apiKey := repository.MapToApiKeyModel(*name, key, scopes, *tenantId, *global)
+	err = repo.Transaction(func(tx repository.Interface) error {
+		if err := tx.CreateApiKey(apiKey); err != nil {
+			return err
+		}
+		return recordCliAction(tx, repository.AuditApiKeyCreate, map[string]interface{}{"id": apiKey.ID, "name": *name, "scopes": scopes, "global": *global}, 1, *tenantId)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("created API key %d, it is only shown once:\n%s\n", apiKey.ID, key)
+	return nil
+}
+
+func listApiKeys(conf *internal.Configuration) error {
+	repo, err := repository.Open(conf)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+	keys, err := repo.ListApiKeys()
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tNAME\tPREFIX\tSCOPES\tTENANT\tCREATED\tLAST USED\tREVOKED")
+	for _, key := range keys {
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", key.ID, key.Name, key.KeyPrefix, key.Scopes, formatTenant(key),
+			key.CreatedAt.Format(time.RFC3339), formatOptionalTime(key.LastUsedAt), formatOptionalTime(key.RevokedAt))
+	}
+	return writer.Flush()
+}
+
+func revokeApiKey(conf *internal.Configuration, args []string) error {
+	flags := flag.NewFlagSet("api-key revoke", flag.ExitOnError)
+	id := flags.Uint("id", 0, "ID of the key to revoke, as listed")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *id == 0 {
+		return errors.New("-id is required")
+	}
+
+	repo, err := repository.Open(conf)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+	err = repo.Transaction(func(tx repository.Interface) error {
+		if err := tx.RevokeApiKey(*id); err != nil {
+			return err
+		}
+		return recordCliAction(tx, repository.AuditApiKeyRevoke, map[string]interface{}{"id": *id}, 1, "")
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("revoked API key %d\n", *id)
+	return nil
+}
+
+func formatTenant(key repository.ApiKey) string {
+	if key.Global {
+		return "*"
+	}
+	if key.Tenant == "" {
+		return "default"
+	}
+	return key.Tenant
+}
+
+func formatOptionalTime(value *time.Time) string {
+	if value == nil {
+		return "-"
+	}
+	return value.Format(time.RFC3339)
+}
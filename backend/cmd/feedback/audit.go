@@ -0,0 +1,74 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package main
+
+import (
+	"errors"
+	"feedback/internal"
+	"feedback/internal/repository"
+	"flag"
+	"fmt"
+	"os/user"
+)
+
+// audit checks the audit log, e.g. `feedback audit verify -head <hash noted earlier>`.
+func audit(conf *internal.Configuration, args []string) error {
+	if len(args) == 0 || args[0] != "verify" {
+		return errors.New("usage: audit verify [-head <hash>]")
+	}
+	flags := flag.NewFlagSet("audit verify", flag.ExitOnError)
+	expectedHead := flags.String("head", "", "hash of an entry that must still be part of the log, e.g. the last one of the previous verification")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	repo, err := repository.Open(conf)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+	if *expectedHead != "" {
+		entries, err := repo.FindAuditEntriesByHash(*expectedHead)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return fmt.Errorf("%w: entry %s is missing", repository.ErrAuditChainBroken, *expectedHead)
+		}
+	}
+
+	count, head, err := repo.VerifyAudit()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("verified %d audit log entries, last hash %s\n", count, head)
+	return nil
+}
+
+// recordCliAction records an administrative action run from the command line, attributed to the OS user.
+func recordCliAction(repo repository.Interface, action string, parameters interface{}, affectedRows int64, tenantId string) error {
+	actor := "cli"
+	if current, err := user.Current(); err == nil {
+		actor += ":" + current.Username
+	}
+	entry, err := repository.MapToAuditEntry(actor, action, parameters, affectedRows, tenantId)
+	if err != nil {
+		return err
+	}
+	return repo.AppendAudit(entry)
+}
@@ -0,0 +1,104 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package main
+
+import (
+	"errors"
+	"feedback/internal"
+	"feedback/internal/auth"
+	"feedback/internal/encryption"
+	"feedback/internal/tenant"
+	"feedback/internal/tlsconfig"
+	"fmt"
+	"os"
+)
+
+// configuration inspects the effective configuration, e.g. `feedback-api config print`.
+func configuration(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: config print|validate")
+	}
+
+	switch args[0] {
+	case "print":
+		conf, err := internal.LoadConfiguration()
+		if err != nil {
+			return err
+		}
+		return conf.Print(os.Stdout)
+	case "validate":
+		return validateConfiguration()
+	}
+	return fmt.Errorf("unknown config command %s, expected print or validate", args[0])
+}
+
+// validateConfiguration loads the configuration and checks that the files it refers to can be read, which the backend
+// otherwise only finds out when it starts. It prints every problem on a line of its own.
+func validateConfiguration() error {
+	conf, err := internal.LoadConfiguration()
+	var loadError *internal.ConfigurationError
+	if errors.As(err, &loadError) {
+		return reportProblems(loadError.Problems)
+	} else if err != nil {
+		return reportProblems([]string{err.Error()})
+	}
+
+	var problems []string
+	if _, err := auth.LoadKeySet(conf); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := encryption.LoadKeyring(conf); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if conf.TenantsFile != "" {
+		tenants, err := tenant.LoadFile(conf.TenantsFile)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+		for _, candidate := range tenants {
+			if _, err := auth.LoadKeySet(candidate.Apply(conf)); err != nil {
+				problems = append(problems, fmt.Sprintf("tenant %s: %v", candidate.Id, err))
+			}
+			if err := auth.ValidateGroupRoles(candidate.AdminOidcGroupRoles); err != nil {
+				problems = append(problems, fmt.Sprintf("tenant %s: %v", candidate.Id, err))
+			}
+		}
+	}
+	if conf.TlsCertFile != "" {
+		if _, err := tlsconfig.New(conf); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if conf.TlsClientCaFile != "" {
+		if _, err := auth.NewClientCertificates(conf); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return reportProblems(problems)
+	}
+	fmt.Println("configuration is valid")
+	return nil
+}
+
+func reportProblems(problems []string) error {
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, problem)
+	}
+	return fmt.Errorf("configuration is invalid, %d problems found", len(problems))
+}
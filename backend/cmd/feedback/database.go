@@ -0,0 +1,54 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package main
+
+import (
+	"errors"
+	"feedback/internal"
+	"feedback/internal/repository"
+	"fmt"
+)
+
+// database checks the database, e.g. `feedback-api db check`, and fails if it is unreachable or migrations are
+// pending.
+func database(conf *internal.Configuration, args []string) error {
+	if len(args) == 0 || args[0] != "check" {
+		return errors.New("usage: db check")
+	}
+	repo, err := repository.Open(conf)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	states, err := repo.MigrationStates()
+	if err != nil {
+		return err
+	}
+	applied := 0
+	for _, state := range states {
+		if state.Applied {
+			applied++
+		}
+	}
+	fmt.Printf("database %s on %s:%s is reachable, %d of %d migrations applied\n", conf.DbName, conf.DbHost, conf.DbPort, applied, len(states))
+	if applied < len(states) {
+		return fmt.Errorf("%d migrations are pending, apply them with migrate up", len(states)-applied)
+	}
+	return nil
+}
@@ -19,24 +19,62 @@ package main
 
 import (
 	"feedback/internal"
-	"feedback/internal/auth"
-	"feedback/internal/controller"
 	"feedback/internal/logger"
-	"feedback/internal/repository"
-	"net/http"
+	"fmt"
+	"os"
+	"strings"
 )
 
 var log = logger.Instance()
 
 func main() {
 	defer log.OnExit()
-	conf := internal.ConfigurationFromEnv()
-	authentication := auth.New(conf)
-	repo := repository.New(conf)
-	repo.Migrate()
-	httpController := controller.New(repo, authentication)
-	router := httpController.GetRouter()
-	log.Info("Starting feedback backend.")
-	err := http.ListenAndServe(":8080", router)
-	log.Fatal(err)
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	if err := run(command, args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run executes a command, e.g. `feedback-api migrate up`. Without one, the backend is served. Commands that only work
+// on the database don't require the settings of the API, and config loads the configuration itself to report on it.
+func run(command string, args []string) error {
+	load := internal.LoadConfiguration
+	switch command {
+	case "serve", "token":
+	case "migrate", "db", "revoke", "api-key", "reencrypt", "audit":
+		load = internal.LoadDatabaseConfiguration
+	case "config":
+		return configuration(args)
+	default:
+		return fmt.Errorf("unknown command %s, expected serve, migrate, token, config, db, revoke, api-key, reencrypt or audit", command)
+	}
+	conf, err := load()
+	if err != nil {
+		return err
+	}
+	if err = logger.Configure(conf); err != nil {
+		return err
+	}
+
+	switch command {
+	case "serve":
+		return start(conf, args)
+	case "migrate":
+		return migrate(conf, args)
+	case "token":
+		return tokens(conf, args)
+	case "db":
+		return database(conf, args)
+	case "revoke":
+		return revoke(conf, args)
+	case "api-key":
+		return apiKeys(conf, args)
+	case "reencrypt":
+		return reencrypt(conf)
+	default:
+		return audit(conf, args)
+	}
 }
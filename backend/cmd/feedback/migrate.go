@@ -0,0 +1,80 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package main
+
+import (
+	"errors"
+	"feedback/internal"
+	"feedback/internal/repository"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+// migrate manages the database schema, e.g. `feedback-api migrate up` in a job that runs before the backend is rolled
+// out with `serve -migrate=false`.
+func migrate(conf *internal.Configuration, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down|status|redo|to <version>")
+	}
+	repo, err := repository.Open(conf)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	switch args[0] {
+	case "up":
+		return repo.MigrateUp()
+	case "down":
+		return repo.MigrateDown()
+	case "redo":
+		return repo.MigrateRedo()
+	case "status":
+		return printMigrationStates(repo)
+	case "to":
+		if len(args) != 2 {
+			return errors.New("usage: migrate to <version>")
+		}
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || version < 0 {
+			return fmt.Errorf("version %s is not a non-negative number", args[1])
+		}
+		return repo.MigrateTo(version)
+	}
+	return fmt.Errorf("unknown migrate command %s, expected up, down, status, redo or to", args[0])
+}
+
+func printMigrationStates(repo *repository.Repository) error {
+	states, err := repo.MigrationStates()
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "VERSION\tMIGRATION\tAPPLIED")
+	for _, state := range states {
+		applied := "no"
+		if state.Applied {
+			applied = "yes"
+		}
+		fmt.Fprintf(writer, "%d\t%s\t%s\n", state.Version, state.Source, applied)
+	}
+	return writer.Flush()
+}
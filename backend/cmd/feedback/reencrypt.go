@@ -0,0 +1,46 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package main
+
+import (
+	"feedback/internal"
+	"feedback/internal/repository"
+	"fmt"
+)
+
+// reencrypt moves all feedback to ENCRYPTION_ACTIVE_KEY, e.g. after adding a new key. Afterwards the old key can be
+// removed from ENCRYPTION_KEYS.
+func reencrypt(conf *internal.Configuration) error {
+	repo, err := repository.Open(conf)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+	changed, err := repo.ReencryptFeedbacks()
+	if changed > 0 {
+		auditErr := recordCliAction(repo, repository.AuditFeedbackReencrypt, map[string]string{"key_version": repo.ActiveKeyVersion()}, changed, "")
+		if err == nil {
+			err = auditErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("re-encrypted %d feedbacks\n", changed)
+	return nil
+}
@@ -0,0 +1,77 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package main
+
+import (
+	"feedback/internal"
+	"feedback/internal/api"
+	"feedback/internal/repository"
+	"flag"
+	"fmt"
+	"time"
+)
+
+// revoke adds an entry to the token denylist, e.g. `feedback revoke -jti <token id>`.
+func revoke(conf *internal.Configuration, args []string) error {
+	flags := flag.NewFlagSet("revoke", flag.ExitOnError)
+	tokenId := flags.String("jti", "", "ID of the token to revoke")
+	subject := flags.String("subject", "", "subject (hashed Matrix user ID) whose tokens to revoke")
+	matrixUserId := flags.String("user", "", "Matrix user ID whose tokens to revoke")
+	before := flags.String("before", "", "revoke all tokens issued before this RFC 3339 timestamp")
+	tenantId := flags.String("tenant", "", "tenant whose tokens to revoke, the default tenant if empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	revocation := api.Revocation{TokenId: *tokenId, Subject: *subject, MatrixUserId: *matrixUserId}
+	if *before != "" {
+		issuedBefore, err := time.Parse(time.RFC3339, *before)
+		if err != nil {
+			return err
+		}
+		revocation.IssuedBefore = &issuedBefore
+	}
+
+	revocationModel, err := repository.MapToRevocationModel(revocation, conf.JwtExpiry, *tenantId)
+	if err != nil {
+		return err
+	}
+
+	repo, err := repository.Open(conf)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+	var revoked int64
+	err = repo.Transaction(func(tx repository.Interface) error {
+		revoked, err = tx.Revoke(revocationModel)
+		if err != nil {
+			return err
+		}
+		if revocation.MatrixUserId != "" {
+			revocation.Subject = *revocationModel.Subject
+			revocation.MatrixUserId = ""
+		}
+		return recordCliAction(tx, repository.AuditTokensRevoke, revocation, revoked, *tenantId)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("revoked %d tokens\n", revoked)
+	return nil
+}
@@ -0,0 +1,188 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"feedback/internal"
+	"feedback/internal/auth"
+	"feedback/internal/controller"
+	"feedback/internal/health"
+	"feedback/internal/liveconfig"
+	"feedback/internal/metrics"
+	"feedback/internal/repository"
+	"feedback/internal/tenant"
+	"feedback/internal/tlsconfig"
+	"feedback/internal/tracing"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// start serves the backend until it is stopped, e.g. `feedback-api serve -migrate=false` if migrations are applied by a
+// job of their own before the backend is rolled out.
+func start(conf *internal.Configuration, args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	autoMigrate := flags.Bool("migrate", true, "apply pending migrations before serving")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	liveConfig := liveconfig.New(conf, internal.LoadConfiguration)
+	liveConfig.UseCheck(func(reloaded *internal.Configuration) error {
+		_, err := auth.LoadKeySet(reloaded)
+		return err
+	})
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+	liveConfig.Watch(watchCtx, os.Getenv(internal.ConfigFileVariable))
+
+	shutdownTracing, err := tracing.Setup(context.Background(), conf)
+	if err != nil {
+		return err
+	}
+
+	repo := repository.New(conf)
+	if *autoMigrate {
+		repo.Migrate()
+	}
+	if _, err = auth.LoadKeySet(conf); err != nil {
+		return err
+	}
+	httpController := controller.New(repo, liveConfig)
+	if conf.RateLimitStore == internal.RateLimitStoreDatabase {
+		httpController.UseRateLimitStore(repo)
+	}
+	if conf.AdminOidcIssuer != "" {
+		adminLogin, err := auth.NewAdminLogin(context.Background(), conf)
+		if err != nil {
+			return err
+		}
+		httpController.UseAdminLogin(adminLogin)
+	}
+	if conf.TlsClientCaFile != "" {
+		clientCertificates, err := auth.NewClientCertificates(conf)
+		if err != nil {
+			return err
+		}
+		httpController.UseClientCertificates(clientCertificates)
+	}
+	var tenants []tenant.Tenant
+	if conf.TenantsFile != "" {
+		if tenants, err = tenant.LoadFile(conf.TenantsFile); err != nil {
+			return err
+		}
+	}
+	httpController.UseTenants(tenant.NewRegistry(tenants, repo))
+	checks := health.NewRegistry()
+	repo.RegisterHealthChecks(checks, conf.HealthCheckTimeout)
+	auth.RegisterHealthChecks(checks, conf.HealthCheckTimeout, liveConfig.Current)
+	httpController.UseHealth(checks)
+	if err := repo.RegisterMetrics(metrics.Registry); err != nil {
+		return err
+	}
+	err = serve(conf, httpController.GetRouter())
+	stopWatching()
+
+	// spans are flushed and the connection pool is closed only once the last request has finished
+	ctx, cancel := context.WithTimeout(context.Background(), conf.ShutdownTimeout)
+	defer cancel()
+	if shutdownErr := shutdownTracing(ctx); shutdownErr != nil {
+		log.Warnw("failed to flush spans", "error", shutdownErr)
+	}
+	if closeErr := repo.Close(); closeErr != nil {
+		log.Warnw("failed to close the database", "error", closeErr)
+	}
+	if err != nil {
+		return err
+	}
+	log.Info("Stopped feedback backend.")
+	return nil
+}
+
+// serve runs the API on LISTEN_ADDRESS and the metrics on METRICS_ADDRESS, apart from the API, until SIGTERM or SIGINT.
+// It then stops accepting connections and waits up to SHUTDOWN_TIMEOUT for the requests in flight, e.g. feedback
+// submitted at the end of a meeting, to finish. With TLS_CERT_FILE, the API is served with TLS and plain HTTP requests
+// to HTTP_REDIRECT_ADDRESS are redirected to it.
+func serve(conf *internal.Configuration, handler http.Handler) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	api := newServer(conf, conf.ListenAddress, handler)
+	metricsRouter := http.NewServeMux()
+	metricsRouter.Handle("/metrics", metrics.Handler())
+	servers := []*http.Server{api, newServer(conf, conf.MetricsAddress, metricsRouter)}
+	if conf.TlsCertFile != "" {
+		var err error
+		if api.TLSConfig, err = tlsconfig.New(conf); err != nil {
+			return err
+		}
+		if conf.HttpRedirectAddress != "" {
+			servers = append(servers, newServer(conf, conf.HttpRedirectAddress, tlsconfig.Redirect(conf.ListenAddress)))
+		}
+	}
+
+	failed := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *http.Server) {
+			var err error
+			if server.TLSConfig != nil {
+				// the certificate is provided by the TLS configuration, so that it can be reloaded
+				err = server.ListenAndServeTLS("", "")
+			} else {
+				err = server.ListenAndServe()
+			}
+			if !errors.Is(err, http.ErrServerClosed) {
+				failed <- err
+			}
+		}(server)
+	}
+	log.Infow("Starting feedback backend.", "address", conf.ListenAddress, "metricsAddress", conf.MetricsAddress)
+
+	var err error
+	select {
+	case <-ctx.Done():
+		log.Infow("Shutting down, waiting for requests in flight.", "timeout", conf.ShutdownTimeout)
+	case err = <-failed:
+	}
+	// a second signal terminates right away
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), conf.ShutdownTimeout)
+	defer cancel()
+	for _, server := range servers {
+		if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil && err == nil {
+			err = shutdownErr
+		}
+	}
+	return err
+}
+
+func newServer(conf *internal.Configuration, address string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:           address,
+		Handler:        handler,
+		ReadTimeout:    conf.ReadTimeout,
+		WriteTimeout:   conf.WriteTimeout,
+		IdleTimeout:    conf.IdleTimeout,
+		MaxHeaderBytes: conf.MaxHeaderBytes,
+	}
+}
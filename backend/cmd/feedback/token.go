@@ -0,0 +1,98 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package main
+
+import (
+	"errors"
+	"feedback/internal"
+	"feedback/internal/auth"
+	"feedback/internal/repository"
+	"feedback/internal/tenant"
+	"flag"
+	"fmt"
+)
+
+// tokens issues tokens without the user verification service, e.g. `feedback-api token mint -user @user:domain.tld` to
+// test a deployment. Minted tokens are registered like issued ones and recorded in the audit log.
+func tokens(conf *internal.Configuration, args []string) error {
+	if len(args) == 0 || args[0] != "mint" {
+		return errors.New("usage: token mint -user <matrix user id> [-meeting <id>] [-tenant <id>]")
+	}
+	flags := flag.NewFlagSet("token mint", flag.ExitOnError)
+	userId := flags.String("user", "", "Matrix user ID the token is issued to")
+	meetingId := flags.String("meeting", "", "meeting the token is restricted to")
+	tenantId := flags.String("tenant", "", "tenant that issues the token, the default tenant if empty")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *userId == "" {
+		return errors.New("-user is required")
+	}
+
+	repo, err := repository.Open(conf)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+	tenantConf, err := tenantConfiguration(conf, repo, *tenantId)
+	if err != nil {
+		return err
+	}
+	keys, err := auth.LoadKeySet(tenantConf)
+	if err != nil {
+		return err
+	}
+	token, claims, err := auth.NewWithKeys(tenantConf, keys, repo).Mint(*userId, *meetingId)
+	if err != nil {
+		return err
+	}
+
+	err = repo.Transaction(func(tx repository.Interface) error {
+		err := tx.RegisterToken(repository.MapToTokenModel(claims.Id, claims.Subject, claims.MeetingId, claims.IssuedAt, claims.ExpiresAt, false, claims.Tenant))
+		if err != nil {
+			return err
+		}
+		parameters := map[string]string{"jti": claims.Id, "subject": claims.Subject, "meeting_id": claims.MeetingId}
+		return recordCliAction(tx, repository.AuditTokenMint, parameters, 1, *tenantId)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(token)
+	return nil
+}
+
+// tenantConfiguration returns the configuration of a tenant of TENANTS_FILE or the database.
+func tenantConfiguration(conf *internal.Configuration, repo *repository.Repository, tenantId string) (*internal.Configuration, error) {
+	if tenantId == "" {
+		return conf, nil
+	}
+	var static []tenant.Tenant
+	if conf.TenantsFile != "" {
+		var err error
+		if static, err = tenant.LoadFile(conf.TenantsFile); err != nil {
+			return nil, err
+		}
+	}
+	for _, candidate := range tenant.NewRegistry(static, repo).Tenants() {
+		if candidate.Id == tenantId {
+			return candidate.Apply(conf), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown tenant %s", tenantId)
+}
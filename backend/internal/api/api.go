@@ -17,11 +17,116 @@
 
 package api
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type Feedback struct {
 	Rating        int                    `json:"rating"`
 	RatingComment string                 `json:"rating_comment"`
 	Metadata      map[string]interface{} `json:"metadata"`
-	Jwt           string                 `json:"jwt"`
+	Consent       *Consent               `json:"consent,omitempty"`
+}
+
+// Consent lists the categories of metadata the user agreed to be collected, as mapped by METADATA_CATEGORIES.
+type Consent struct {
+	Categories []string `json:"categories"`
+}
+
+// Includes tells whether the user consented to a category. Without consent, no category is included.
+func (consent *Consent) Includes(category string) bool {
+	if consent == nil {
+		return false
+	}
+	for _, given := range consent.Categories {
+		if given == category {
+			return true
+		}
+	}
+	return false
+}
+
+// StoredFeedback is feedback as returned by the admin API.
+type StoredFeedback struct {
+	Id            uint                   `json:"id"`
+	CreatedAt     time.Time              `json:"created_at"`
+	Rating        int                    `json:"rating"`
+	RatingComment string                 `json:"rating_comment"`
+	Metadata      map[string]interface{} `json:"metadata"`
+	Anonymous     bool                   `json:"anonymous"`
+	Survey        string                 `json:"survey,omitempty"`
+	Consent       *Consent               `json:"consent,omitempty"`
+	// Suppressed tells whether the quasi-identifiers were removed from the metadata, as their group is too small.
+	Suppressed bool `json:"suppressed"`
+}
+
+// AdminSession describes the session of an administrator logged in through the identity provider.
+type AdminSession struct {
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	CsrfToken string    `json:"csrf_token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// AuditEntry is an entry of the audit log of administrative actions.
+type AuditEntry struct {
+	Sequence     int64           `json:"sequence"`
+	CreatedAt    time.Time       `json:"created_at"`
+	Actor        string          `json:"actor"`
+	Action       string          `json:"action"`
+	Parameters   json.RawMessage `json:"parameters"`
+	AffectedRows int64           `json:"affected_rows"`
+	PreviousHash string          `json:"previous_hash"`
+	Hash         string          `json:"hash"`
+	Tenant       string          `json:"tenant,omitempty"`
+}
+
+// Statistics aggregates feedback in buckets of equal metadata values. Buckets of fewer than MinGroupSize feedbacks
+// are merged into one or suppressed, so no bucket describes few individuals.
+type Statistics struct {
+	MinGroupSize int                `json:"min_group_size"`
+	Buckets      []StatisticsBucket `json:"buckets"`
+	// Privacy describes the noise added to the buckets, if any.
+	Privacy *StatisticsPrivacy `json:"privacy,omitempty"`
+}
+
+type StatisticsPrivacy struct {
+	Noise   string  `json:"noise"`
+	Epsilon float64 `json:"epsilon"`
+	Delta   float64 `json:"delta,omitempty"`
+	// RemainingBudget is the epsilon left in the current window, which ends at BudgetResetAt.
+	RemainingBudget float64   `json:"remaining_budget"`
+	BudgetResetAt   time.Time `json:"budget_reset_at"`
+}
+
+type StatisticsBucket struct {
+	// Group holds the metadata values of the bucket. It is empty for the bucket of merged groups.
+	Group         map[string]interface{} `json:"group,omitempty"`
+	Count         int                    `json:"count,omitempty"`
+	AverageRating float64                `json:"average_rating,omitempty"`
+	// MergedGroups is the number of small groups merged into this bucket.
+	MergedGroups int `json:"merged_groups,omitempty"`
+	// Suppressed buckets are too small to be reported, so they come without count and rating.
+	Suppressed bool `json:"suppressed,omitempty"`
+}
+
+// Health is the result of the readiness checks. Status is ok, degraded if a non-critical check failed or unavailable
+// if a critical one failed.
+type Health struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+type HealthCheck struct {
+	Status     string `json:"status"`
+	Critical   bool   `json:"critical"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+type DeletionResponse struct {
+	DeletedFeedbacks int64 `json:"deleted_feedbacks"`
 }
 
 type ValidationResponse struct {
@@ -30,3 +135,30 @@ type ValidationResponse struct {
 	} `json:"results"`
 	UserId string `json:"user_id"`
 }
+
+type Revocation struct {
+	TokenId      string     `json:"token_id"`
+	Subject      string     `json:"subject"`
+	MatrixUserId string     `json:"matrix_user_id"`
+	IssuedBefore *time.Time `json:"issued_before"`
+}
+
+type RevocationResponse struct {
+	RevokedTokens int64 `json:"revoked_tokens"`
+}
+
+type LogLevel struct {
+	Level string `json:"level"`
+}
+
+type Challenge struct {
+	Challenge  string    `json:"challenge"`
+	Difficulty int       `json:"difficulty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type AnonymousTokenRequest struct {
+	Challenge string `json:"challenge"`
+	Nonce     string `json:"nonce"`
+	MeetingId string `json:"meeting_id"`
+}
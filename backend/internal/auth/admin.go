@@ -0,0 +1,144 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	ScopeRead            = "read"
+	ScopeExport          = "export"
+	ScopeDelete          = "delete"
+	ScopeManageSurveys   = "manage-surveys"
+	ScopeManageLogging   = "manage-logging"
+	ScopeExactStatistics = "exact-statistics"
+
+	// ApiKeyPrefix tells API keys apart from other bearer tokens, e.g. in secret scanners.
+	ApiKeyPrefix = "fbk_"
+	// AdminTokenName is the name under which requests authorized by ADMIN_TOKEN are attributed.
+	AdminTokenName = "admin-token"
+)
+
+var Scopes = []string{ScopeRead, ScopeExport, ScopeDelete, ScopeManageSurveys, ScopeManageLogging, ScopeExactStatistics}
+
+// Admin is the identity behind an administrative request.
+type Admin struct {
+	Name   string
+	Scopes []string
+	// Tenant restricts the admin to the data of one tenant, where the default tenant has the empty ID.
+	Tenant string
+	// Global admins may act on every tenant, regardless of Tenant.
+	Global bool
+}
+
+// MayAccess tells whether the admin may act on the data of a tenant.
+func (admin *Admin) MayAccess(tenantId string) bool {
+	return admin.Global || admin.Tenant == tenantId
+}
+
+func (admin *Admin) HasScope(scope string) bool {
+	for _, granted := range admin.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type ApiKeyStore interface {
+	// UseApiKey returns the admin an active API key belongs to and records that it was used.
+	UseApiKey(keyHash string) (*Admin, error)
+}
+
+type adminContextKey struct{}
+
+func WithAdmin(ctx context.Context, admin *Admin) context.Context {
+	return context.WithValue(ctx, adminContextKey{}, admin)
+}
+
+// AdminFrom returns the admin that was authorized for a request, or nil.
+func AdminFrom(ctx context.Context) *Admin {
+	admin, _ := ctx.Value(adminContextKey{}).(*Admin)
+	return admin
+}
+
+// NewApiKey returns a random API key. Only its hash is stored, so it can't be shown again.
+func NewApiKey() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return ApiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// HashApiKey hashes an API key for storage. Keys are random, so a fast hash is sufficient.
+func HashApiKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// ParseScopes parses a comma separated list of scopes.
+func ParseScopes(value string) ([]string, error) {
+	var scopes []string
+	for _, scope := range strings.Split(value, ",") {
+		scope = strings.TrimSpace(scope)
+		if scope == "" {
+			continue
+		}
+		if !(&Admin{Scopes: Scopes}).HasScope(scope) {
+			return nil, fmt.Errorf("unknown scope %s, expected one of %s", scope, strings.Join(Scopes, ", "))
+		}
+		scopes = append(scopes, scope)
+	}
+	if len(scopes) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+	return scopes, nil
+}
+
+// AuthorizeAdmin authenticates the bearer token of an administrative request, which is either an API key or
+// ADMIN_TOKEN. ADMIN_TOKEN grants all scopes on every tenant.
+func (auth OidcAuthentication) AuthorizeAdmin(request *http.Request, apiKeys ApiKeyStore) (*Admin, error) {
+	token, err := auth.ExtractTokenFrom(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(*token, ApiKeyPrefix) {
+		admin, err := apiKeys.UseApiKey(HashApiKey(*token))
+		if err != nil {
+			return nil, errors.New("API key is not valid")
+		}
+		return admin, nil
+	}
+
+	if auth.config.AdminToken != "" && subtle.ConstantTimeCompare([]byte(*token), []byte(auth.config.AdminToken)) == 1 {
+		return &Admin{Name: AdminTokenName, Scopes: Scopes, Global: true}, nil
+	}
+	return nil, errors.New("admin token is not valid")
+}
@@ -0,0 +1,372 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"feedback/internal"
+	"feedback/internal/tenant"
+	"fmt"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/golang-jwt/jwt"
+	"golang.org/x/oauth2"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	RoleViewer  = "viewer"
+	RoleAnalyst = "analyst"
+	RoleAdmin   = "admin"
+
+	SessionCookie = "feedback_admin_session"
+	LoginCookie   = "feedback_admin_login"
+	CsrfHeader    = "X-CSRF-Token"
+
+	// LoginExpiry limits how long the identity provider may take to redirect back.
+	LoginExpiry = 10 * time.Minute
+)
+
+// RoleScopes are the scopes granted by each role that IdP groups can be mapped to.
+var RoleScopes = map[string][]string{
+	RoleViewer:  {ScopeRead},
+	RoleAnalyst: {ScopeRead, ScopeExport},
+	RoleAdmin:   Scopes,
+}
+
+// SessionClaims are carried by the session cookie of a logged-in administrator. The session is only valid for the
+// tenant it was started on.
+type SessionClaims struct {
+	jwt.StandardClaims
+	Scopes    []string `json:"scopes"`
+	CsrfToken string   `json:"csrf"`
+	Tenant    string   `json:"tenant,omitempty"`
+}
+
+// loginClaims are carried by the login cookie between redirecting to the identity provider and its callback.
+type loginClaims struct {
+	jwt.StandardClaims
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+	ReturnTo     string `json:"return_to"`
+	Tenant       string `json:"tenant,omitempty"`
+}
+
+// AdminLogin logs administrators in with the authorization code flow of an OpenID Connect identity provider. Every
+// tenant is called back at its own redirect URL and maps groups to roles with its own ADMIN_OIDC_GROUP_ROLES, if set.
+type AdminLogin struct {
+	config      *internal.Configuration
+	oauth2      oauth2.Config
+	verifier    *oidc.IDTokenVerifier
+	redirectUrl *url.URL
+}
+
+// NewAdminLogin discovers the identity provider at ADMIN_OIDC_ISSUER.
+func NewAdminLogin(ctx context.Context, config *internal.Configuration) (*AdminLogin, error) {
+	if err := ValidateGroupRoles(config.AdminOidcGroupRoles); err != nil {
+		return nil, err
+	}
+	redirectUrl, err := url.Parse(config.AdminOidcRedirectUrl)
+	if err != nil || !redirectUrl.IsAbs() {
+		return nil, fmt.Errorf("ADMIN_OIDC_REDIRECT_URL %s is not an absolute URL", config.AdminOidcRedirectUrl)
+	}
+
+	provider, err := oidc.NewProvider(ctx, config.AdminOidcIssuer)
+	if err != nil {
+		return nil, err
+	}
+	return &AdminLogin{
+		config: config,
+		oauth2: oauth2.Config{
+			ClientID:     config.AdminOidcClientId,
+			ClientSecret: config.AdminOidcClientSecret,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+		},
+		verifier:    provider.Verifier(&oidc.Config{ClientID: config.AdminOidcClientId}),
+		redirectUrl: redirectUrl,
+	}, nil
+}
+
+// ValidateGroupRoles checks that groups are only mapped to known roles.
+func ValidateGroupRoles(groupRoles map[string]string) error {
+	for group, role := range groupRoles {
+		if _, found := RoleScopes[role]; !found {
+			return fmt.Errorf("group %s is mapped to unknown role %s", group, role)
+		}
+	}
+	return nil
+}
+
+// oauth2For returns the OAuth 2.0 configuration calling back to the redirect URL of the tenant of a request. Tenants
+// without a redirect URL of their own are called back on the host they were resolved by, or below their path prefix.
+func (login *AdminLogin) oauth2For(request *http.Request) *oauth2.Config {
+	config := login.oauth2
+	current := tenant.From(request.Context())
+	if current != nil && current.AdminOidcRedirectUrl != "" {
+		config.RedirectURL = current.AdminOidcRedirectUrl
+		return &config
+	}
+
+	redirectUrl := *login.redirectUrl
+	if current != nil {
+		prefix := tenant.PathPrefixFrom(request.Context())
+		if prefix == "" {
+			redirectUrl.Host = request.Host
+		}
+		redirectUrl.Path = prefix + redirectUrl.Path
+		redirectUrl.RawPath = ""
+	}
+	config.RedirectURL = redirectUrl.String()
+	return &config
+}
+
+// Start redirects to the identity provider. State, nonce and PKCE verifier are kept in a signed cookie.
+func (login *AdminLogin) Start(writer http.ResponseWriter, request *http.Request) error {
+	state, err := randomString()
+	if err != nil {
+		return err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return err
+	}
+	codeVerifier, err := randomString()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	cookie, err := login.sign(&loginClaims{
+		StandardClaims: jwt.StandardClaims{IssuedAt: now.Unix(), ExpiresAt: now.Add(LoginExpiry).Unix(), Audience: LoginCookie},
+		State:          state,
+		Nonce:          nonce,
+		CodeVerifier:   codeVerifier,
+		ReturnTo:       localPath(request.URL.Query().Get("return_to"), tenant.PathPrefixFrom(request.Context())+"/admin/session"),
+		Tenant:         tenant.IdFrom(request.Context()),
+	})
+	if err != nil {
+		return err
+	}
+	login.setCookie(writer, request, LoginCookie, cookie, LoginExpiry)
+
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	http.Redirect(writer, request, login.oauth2For(request).AuthCodeURL(state,
+		oidc.Nonce(nonce),
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	), http.StatusFound)
+	return nil
+}
+
+// Finish handles the callback of the identity provider. It exchanges the code for an ID token, maps the groups of
+// the administrator to scopes and starts a session. It returns the path to continue at.
+func (login *AdminLogin) Finish(writer http.ResponseWriter, request *http.Request) (*Admin, string, error) {
+	cookie, err := request.Cookie(LoginCookie)
+	if err != nil {
+		return nil, "", errors.New("login has not been started")
+	}
+	login.setCookie(writer, request, LoginCookie, "", -1)
+
+	var started loginClaims
+	err = login.parse(cookie.Value, LoginCookie, &started)
+	if err != nil {
+		return nil, "", err
+	}
+	if started.Tenant != tenant.IdFrom(request.Context()) {
+		return nil, "", errors.New("login was started for another tenant")
+	}
+	query := request.URL.Query()
+	if errorCode := query.Get("error"); errorCode != "" {
+		return nil, "", fmt.Errorf("identity provider refused login: %s", errorCode)
+	}
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(started.State)) != 1 {
+		return nil, "", errors.New("login state does not match")
+	}
+
+	token, err := login.oauth2For(request).Exchange(request.Context(), query.Get("code"),
+		oauth2.SetAuthURLParam("code_verifier", started.CodeVerifier))
+	if err != nil {
+		return nil, "", err
+	}
+	rawIdToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, "", errors.New("identity provider returned no ID token")
+	}
+	idToken, err := login.verifier.Verify(request.Context(), rawIdToken)
+	if err != nil {
+		return nil, "", err
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(started.Nonce)) != 1 {
+		return nil, "", errors.New("ID token nonce does not match")
+	}
+
+	admin, err := login.adminFrom(idToken, tenant.From(request.Context()).Apply(login.config).AdminOidcGroupRoles)
+	if err != nil {
+		return nil, "", err
+	}
+	csrfToken, err := randomString()
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now()
+	session, err := login.sign(&SessionClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   admin.Name,
+			Audience:  SessionCookie,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(login.config.SessionDuration).Unix(),
+		},
+		Scopes:    admin.Scopes,
+		CsrfToken: csrfToken,
+		Tenant:    started.Tenant,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	login.setCookie(writer, request, SessionCookie, session, login.config.SessionDuration)
+	admin.Tenant = started.Tenant
+	return admin, started.ReturnTo, nil
+}
+
+// adminFrom grants the scopes of all roles the groups of the ID token are mapped to.
+func (login *AdminLogin) adminFrom(idToken *oidc.IDToken, groupRoles map[string]string) (*Admin, error) {
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	name, _ := claims["email"].(string)
+	if name == "" {
+		name = idToken.Subject
+	}
+
+	granted := map[string]bool{}
+	groups, _ := claims[login.config.AdminOidcGroupsClaim].([]interface{})
+	for _, group := range groups {
+		groupName, _ := group.(string)
+		for _, scope := range RoleScopes[groupRoles[groupName]] {
+			granted[scope] = true
+		}
+	}
+	if len(granted) == 0 {
+		return nil, fmt.Errorf("%s is in no group with an admin role", name)
+	}
+
+	admin := &Admin{Name: name}
+	for scope := range granted {
+		admin.Scopes = append(admin.Scopes, scope)
+	}
+	sort.Strings(admin.Scopes)
+	return admin, nil
+}
+
+// Authenticate returns the admin of the session cookie of a request. Requests that may change state must repeat
+// the CSRF token of the session in the X-CSRF-Token header, which other sites can't read or set.
+func (login *AdminLogin) Authenticate(request *http.Request) (*Admin, *SessionClaims, error) {
+	cookie, err := request.Cookie(SessionCookie)
+	if err != nil {
+		return nil, nil, errors.New("not logged in")
+	}
+	var session SessionClaims
+	err = login.parse(cookie.Value, SessionCookie, &session)
+	if err != nil {
+		return nil, nil, err
+	}
+	if session.Tenant != tenant.IdFrom(request.Context()) {
+		return nil, nil, errors.New("session belongs to another tenant")
+	}
+
+	switch request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		if subtle.ConstantTimeCompare([]byte(request.Header.Get(CsrfHeader)), []byte(session.CsrfToken)) != 1 {
+			return nil, nil, errors.New("CSRF token is missing or not valid")
+		}
+	}
+	return &Admin{Name: session.Subject, Scopes: session.Scopes, Tenant: session.Tenant}, &session, nil
+}
+
+func (login *AdminLogin) Logout(writer http.ResponseWriter, request *http.Request) {
+	login.setCookie(writer, request, SessionCookie, "", -1)
+}
+
+func (login *AdminLogin) sign(claims jwt.Claims) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(login.config.SessionSecret))
+}
+
+func (login *AdminLogin) parse(value string, audience string, claims interface {
+	jwt.Claims
+	VerifyAudience(string, bool) bool
+}) error {
+	_, err := jwt.ParseWithClaims(value, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, errors.New("unexpected signing method: " + token.Method.Alg())
+		}
+		return []byte(login.config.SessionSecret), nil
+	})
+	if err != nil {
+		return err
+	}
+	if !claims.VerifyAudience(audience, true) {
+		return errors.New("cookie is not a " + audience)
+	}
+	return nil
+}
+
+// setCookie sets a cookie for the admin routes of the tenant of the request, or deletes it if maxAge is negative.
+func (login *AdminLogin) setCookie(writer http.ResponseWriter, request *http.Request, name string, value string, maxAge time.Duration) {
+	if maxAge < 0 {
+		maxAge = -time.Second
+	}
+	http.SetCookie(writer, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     tenant.PathPrefixFrom(request.Context()) + "/admin",
+		MaxAge:   int(maxAge.Seconds()),
+		Secure:   strings.HasPrefix(login.oauth2For(request).RedirectURL, "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// localPath only allows paths on this server as targets after login, so the login can't redirect elsewhere.
+func localPath(target string, fallback string) string {
+	parsed, err := url.Parse(target)
+	if err != nil || target == "" || parsed.IsAbs() || parsed.Host != "" || !strings.HasPrefix(parsed.Path, "/") ||
+		strings.HasPrefix(target, "//") || strings.Contains(target, "\\") {
+		return fallback
+	}
+	return target
+}
+
+func randomString() (string, error) {
+	value := make([]byte, 32)
+	if _, err := rand.Read(value); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(value), nil
+}
@@ -0,0 +1,254 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"feedback/internal"
+	"feedback/internal/tenant"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// fakeIdentityProvider stands in for an OpenID Connect identity provider. It issues an ID token for the code
+// "someCode" to whoever proves the PKCE challenge and repeats the redirect URI of the last authorization request.
+type fakeIdentityProvider struct {
+	server        *httptest.Server
+	keys          *KeySet
+	nonce         string
+	codeChallenge string
+	redirectUri   string
+	groups        []string
+}
+
+func newFakeIdentityProvider(t *testing.T) *fakeIdentityProvider {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	keys, err := LoadKeySet(&internal.Configuration{JwtKeys: "idp=" + writeKey(t, "idp", rsaKey)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &fakeIdentityProvider{keys: keys}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(writer http.ResponseWriter, request *http.Request) {
+		_ = json.NewEncoder(writer).Encode(map[string]interface{}{
+			"issuer":                                idp.server.URL,
+			"authorization_endpoint":                idp.server.URL + "/authorize",
+			"token_endpoint":                        idp.server.URL + "/token",
+			"jwks_uri":                              idp.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(writer http.ResponseWriter, request *http.Request) {
+		_ = json.NewEncoder(writer).Encode(idp.keys.Jwks())
+	})
+	mux.HandleFunc("/token", func(writer http.ResponseWriter, request *http.Request) {
+		verifier := sha256.Sum256([]byte(request.FormValue("code_verifier")))
+		if request.FormValue("code") != "someCode" || base64.RawURLEncoding.EncodeToString(verifier[:]) != idp.codeChallenge ||
+			request.FormValue("redirect_uri") != idp.redirectUri {
+			http.Error(writer, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		idToken, _ := idp.keys.Sign(jwt.MapClaims{
+			"iss":    idp.server.URL,
+			"aud":    "someClientId",
+			"sub":    "someAdminId",
+			"email":  "admin@domain.tld",
+			"groups": idp.groups,
+			"nonce":  idp.nonce,
+			"iat":    time.Now().Unix(),
+			"exp":    time.Now().Add(time.Minute).Unix(),
+		})
+		writer.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(writer).Encode(map[string]string{"access_token": "someAccessToken", "token_type": "Bearer", "id_token": idToken})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func adminLoginConfiguration(issuer string) *internal.Configuration {
+	return &internal.Configuration{
+		AdminOidcIssuer:      issuer,
+		AdminOidcClientId:    "someClientId",
+		AdminOidcRedirectUrl: "http://localhost:8080/admin/callback",
+		AdminOidcGroupsClaim: "groups",
+		AdminOidcGroupRoles:  map[string]string{"feedback-analysts": RoleAnalyst},
+		SessionSecret:        "someSessionSecret",
+		SessionDuration:      time.Hour,
+	}
+}
+
+// tenants resolves requests below /acme to the tenant acme and requests to beta.domain.tld to the tenant beta, which
+// maps groups to roles of its own.
+var tenants = tenant.NewRegistry([]tenant.Tenant{
+	{Id: "acme", PathPrefix: "/acme"},
+	{Id: "beta", Hosts: []string{"beta.domain.tld"}, AdminOidcGroupRoles: map[string]string{"beta-admins": RoleAdmin}},
+}, nil)
+
+// logIn runs the authorization code flow at the base URL and returns the response of the callback, which is requested
+// at the redirect URI passed to the identity provider.
+func logIn(t *testing.T, login *AdminLogin, idp *fakeIdentityProvider, base string, state func(string) string) *httptest.ResponseRecorder {
+	prefix, _ := url.Parse(base)
+	responseWriter := httptest.NewRecorder()
+	err := login.Start(responseWriter, tenants.Resolve(httptest.NewRequest(http.MethodGet, base+"/admin/login?return_to="+prefix.Path+"/admin/feedback", nil)))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusFound, responseWriter.Code)
+	redirect, _ := url.Parse(responseWriter.Header().Get("Location"))
+	idp.nonce = redirect.Query().Get("nonce")
+	idp.codeChallenge = redirect.Query().Get("code_challenge")
+	idp.redirectUri = redirect.Query().Get("redirect_uri")
+
+	callback := httptest.NewRequest(http.MethodGet, idp.redirectUri+"?code=someCode&state="+state(redirect.Query().Get("state")), nil)
+	for _, cookie := range responseWriter.Result().Cookies() {
+		callback.AddCookie(cookie)
+	}
+	responseWriter = httptest.NewRecorder()
+	_, _, err = login.Finish(responseWriter, tenants.Resolve(callback))
+	if err != nil {
+		responseWriter.Code = http.StatusForbidden
+	}
+	return responseWriter
+}
+
+func sessionCookie(responseWriter *httptest.ResponseRecorder) *http.Cookie {
+	for _, cookie := range responseWriter.Result().Cookies() {
+		if cookie.Name == SessionCookie && cookie.Value != "" {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestAdminLogin_MapsGroupsToScopes(t *testing.T) {
+	idp := newFakeIdentityProvider(t)
+	idp.groups = []string{"feedback-analysts", "others"}
+	login, err := NewAdminLogin(context.Background(), adminLoginConfiguration(idp.server.URL))
+	assert.Nil(t, err)
+
+	responseWriter := logIn(t, login, idp, "", func(state string) string { return state })
+	cookie := sessionCookie(responseWriter)
+	assert.NotNil(t, cookie)
+	assert.True(t, cookie.HttpOnly)
+
+	request := httptest.NewRequest(http.MethodGet, "/admin/feedback", nil)
+	request.AddCookie(cookie)
+	admin, session, err := login.Authenticate(request)
+	assert.Nil(t, err)
+	assert.Equal(t, "admin@domain.tld", admin.Name)
+	assert.Equal(t, []string{ScopeExport, ScopeRead}, admin.Scopes)
+
+	request = httptest.NewRequest(http.MethodDelete, "/admin/feedback", nil)
+	request.AddCookie(cookie)
+	_, _, err = login.Authenticate(request)
+	assert.EqualError(t, err, "CSRF token is missing or not valid")
+
+	request.Header.Set(CsrfHeader, session.CsrfToken)
+	_, _, err = login.Authenticate(request)
+	assert.Nil(t, err)
+}
+
+func TestAdminLogin_Rejections(t *testing.T) {
+	idp := newFakeIdentityProvider(t)
+	login, err := NewAdminLogin(context.Background(), adminLoginConfiguration(idp.server.URL))
+	assert.Nil(t, err)
+
+	idp.groups = []string{"feedback-analysts"}
+	responseWriter := logIn(t, login, idp, "", func(string) string { return "forgedState" })
+	assert.Equal(t, http.StatusForbidden, responseWriter.Code)
+	assert.Nil(t, sessionCookie(responseWriter))
+
+	idp.groups = []string{"others"}
+	responseWriter = logIn(t, login, idp, "", func(state string) string { return state })
+	assert.Equal(t, http.StatusForbidden, responseWriter.Code)
+	assert.Nil(t, sessionCookie(responseWriter))
+}
+
+func TestAdminLogin_BindsSessionToTenant(t *testing.T) {
+	idp := newFakeIdentityProvider(t)
+	idp.groups = []string{"feedback-analysts"}
+	login, err := NewAdminLogin(context.Background(), adminLoginConfiguration(idp.server.URL))
+	assert.Nil(t, err)
+
+	responseWriter := logIn(t, login, idp, "/acme", func(state string) string { return state })
+	assert.Equal(t, "http://localhost:8080/acme/admin/callback", idp.redirectUri)
+	cookie := sessionCookie(responseWriter)
+	assert.Equal(t, "/acme/admin", cookie.Path)
+
+	request := httptest.NewRequest(http.MethodGet, "/acme/admin/feedback", nil)
+	request.AddCookie(cookie)
+	admin, _, err := login.Authenticate(tenants.Resolve(request))
+	assert.Nil(t, err)
+	assert.Equal(t, "acme", admin.Tenant)
+
+	request = httptest.NewRequest(http.MethodGet, "/admin/feedback", nil)
+	request.AddCookie(cookie)
+	_, _, err = login.Authenticate(tenants.Resolve(request))
+	assert.EqualError(t, err, "session belongs to another tenant")
+}
+
+func TestAdminLogin_UsesSettingsOfTenant(t *testing.T) {
+	idp := newFakeIdentityProvider(t)
+	login, err := NewAdminLogin(context.Background(), adminLoginConfiguration(idp.server.URL))
+	assert.Nil(t, err)
+
+	idp.groups = []string{"feedback-analysts"}
+	responseWriter := logIn(t, login, idp, "http://beta.domain.tld", func(state string) string { return state })
+	assert.Equal(t, "http://beta.domain.tld/admin/callback", idp.redirectUri)
+	assert.Nil(t, sessionCookie(responseWriter))
+
+	idp.groups = []string{"beta-admins"}
+	responseWriter = logIn(t, login, idp, "http://beta.domain.tld", func(state string) string { return state })
+	cookie := sessionCookie(responseWriter)
+	assert.NotNil(t, cookie)
+
+	request := httptest.NewRequest(http.MethodGet, "http://beta.domain.tld/admin/feedback", nil)
+	request.AddCookie(cookie)
+	admin, _, err := login.Authenticate(tenants.Resolve(request))
+	assert.Nil(t, err)
+	assert.Equal(t, "beta", admin.Tenant)
+	assert.ElementsMatch(t, Scopes, admin.Scopes)
+
+	own := &tenant.Tenant{Id: "gamma", AdminOidcRedirectUrl: "https://gamma.domain.tld/feedback/admin/callback"}
+	request = httptest.NewRequest(http.MethodGet, "/admin/login", nil)
+	request = request.WithContext(tenant.WithTenant(request.Context(), own))
+	assert.Equal(t, own.AdminOidcRedirectUrl, login.oauth2For(request).RedirectURL)
+}
+
+func TestLocalPath(t *testing.T) {
+	for target, expected := range map[string]string{
+		"/admin/feedback?limit=10": "/admin/feedback?limit=10",
+		"":                         "/admin/session",
+		"https://evil.tld/":        "/admin/session",
+		"//evil.tld/":              "/admin/session",
+		"/\\evil.tld":              "/admin/session",
+		"admin/feedback":           "/admin/session",
+	} {
+		assert.Equal(t, expected, localPath(target, "/admin/session"), target)
+	}
+}
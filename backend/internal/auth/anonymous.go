@@ -0,0 +1,113 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package auth
+
+import (
+	"crypto/sha256"
+	"errors"
+	"feedback/internal/api"
+	"github.com/golang-jwt/jwt"
+	"math/bits"
+	"time"
+)
+
+const (
+	AnonymousSubject = "anonymous"
+	ChallengeSubject = "pow-challenge"
+	ChallengeExpiry  = 5 * time.Minute
+)
+
+type ChallengeClaims struct {
+	jwt.StandardClaims
+	Difficulty int `json:"difficulty"`
+}
+
+// NewChallenge issues a signed proof-of-work challenge. Its audience differs from the one of feedback tokens, so a
+// challenge is never accepted in place of a feedback token.
+func (auth OidcAuthentication) NewChallenge() (*api.Challenge, error) {
+	challengeId, err := newTokenId()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	claims := &ChallengeClaims{
+		StandardClaims: jwt.StandardClaims{
+			Audience:  auth.challengeAudience(),
+			ExpiresAt: now.Add(ChallengeExpiry).Unix(),
+			Id:        challengeId,
+			IssuedAt:  now.Unix(),
+			Issuer:    auth.config.JwtIssuer,
+			Subject:   ChallengeSubject,
+		},
+		Difficulty: auth.config.PowDifficulty,
+	}
+	challenge, err := auth.keys.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.Challenge{
+		Challenge:  challenge,
+		Difficulty: claims.Difficulty,
+		ExpiresAt:  time.Unix(claims.ExpiresAt, 0),
+	}, nil
+}
+
+// ValidateAnonymous checks the solved challenge and issues an anonymous feedback token. The token reuses the ID of
+// the challenge, so registering it a second time fails and each challenge yields one token only.
+func (auth OidcAuthentication) ValidateAnonymous(request api.AnonymousTokenRequest) (*string, *FeedbackClaims, error) {
+	claims := &ChallengeClaims{}
+	token, err := jwt.ParseWithClaims(request.Challenge, claims, func(token *jwt.Token) (interface{}, error) {
+		key, err := auth.keys.Lookup(token)
+		if err != nil {
+			return nil, err
+		}
+		return key.Public, nil
+	})
+	if _, err = auth.validateJwt(token, err); err != nil {
+		return nil, nil, err
+	}
+	if claims.Subject != ChallengeSubject || claims.Id == "" ||
+		!claims.VerifyIssuer(auth.config.JwtIssuer, true) || !claims.VerifyAudience(auth.challengeAudience(), true) {
+		return nil, nil, errors.New("challenge is not valid")
+	}
+	if !IsSolved(request.Challenge, request.Nonce, claims.Difficulty) {
+		return nil, nil, errors.New("challenge is not solved")
+	}
+
+	tokenString, feedbackClaims, err := auth.sign(claims.Id, AnonymousSubject, request.MeetingId, true)
+	return &tokenString, feedbackClaims, err
+}
+
+// IsSolved tells whether SHA-256(challenge + nonce) starts with at least difficulty zero bits.
+func IsSolved(challenge string, nonce string, difficulty int) bool {
+	sum := sha256.Sum256([]byte(challenge + nonce))
+	zeroBits := 0
+	for _, b := range sum {
+		zeroBits += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return zeroBits >= difficulty
+}
+
+func (auth OidcAuthentication) challengeAudience() string {
+	return auth.config.JwtAudience + "/challenge"
+}
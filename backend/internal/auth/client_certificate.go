@@ -0,0 +1,66 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package auth
+
+import (
+	"errors"
+	"feedback/internal"
+	"fmt"
+	"net/http"
+)
+
+// ClientCertificatePrefix is prepended to the common name of a client certificate to name the admin it belongs to.
+const ClientCertificatePrefix = "cert:"
+
+// ClientCertificates authenticates administrators by certificates issued by one of the CAs of TLS_CLIENT_CA_FILE.
+type ClientCertificates struct {
+	roles map[string]string
+}
+
+// NewClientCertificates checks that TLS_CLIENT_ROLES only maps common names to known roles.
+func NewClientCertificates(config *internal.Configuration) (*ClientCertificates, error) {
+	for commonName, role := range config.TlsClientRoles {
+		if _, found := RoleScopes[role]; !found {
+			return nil, fmt.Errorf("certificate %s is mapped to unknown role %s", commonName, role)
+		}
+	}
+	return &ClientCertificates{config.TlsClientRoles}, nil
+}
+
+// HasClientCertificate tells whether a request was sent with a client certificate verified during the handshake.
+func HasClientCertificate(request *http.Request) bool {
+	return request.TLS != nil && len(request.TLS.VerifiedChains) > 0
+}
+
+// Authenticate returns the admin whose client certificate a request was sent with. The scopes are those of the role
+// its common name is mapped to, on every tenant. Client certificates are meant for scripts, so requests from browsers, which send an
+// Origin header, are rejected; browsers would present the certificate to forged cross-site requests as well.
+func (certificates *ClientCertificates) Authenticate(request *http.Request) (*Admin, error) {
+	if !HasClientCertificate(request) {
+		return nil, errors.New("no verified client certificate")
+	}
+	if request.Header.Get("Origin") != "" {
+		return nil, errors.New("client certificates are not accepted from browsers")
+	}
+	commonName := request.TLS.VerifiedChains[0][0].Subject.CommonName
+	role, found := certificates.roles[commonName]
+	if !found {
+		return nil, fmt.Errorf("client certificate %s is not mapped to a role", commonName)
+	}
+	return &Admin{Name: ClientCertificatePrefix + commonName, Scopes: RoleScopes[role], Global: true}, nil
+}
@@ -0,0 +1,60 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"feedback/internal"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func requestWithCertificate(commonName string) *http.Request {
+	request := httptest.NewRequest(http.MethodGet, "/admin/feedback", nil)
+	certificate := &x509.Certificate{Subject: pkix.Name{CommonName: commonName}}
+	request.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{certificate}}}
+	return request
+}
+
+func TestClientCertificates_Authenticate(t *testing.T) {
+	_, err := NewClientCertificates(&internal.Configuration{TlsClientRoles: map[string]string{"ops": "root"}})
+	assert.EqualError(t, err, "certificate ops is mapped to unknown role root")
+
+	certificates, err := NewClientCertificates(&internal.Configuration{TlsClientRoles: map[string]string{"reporting": RoleAnalyst}})
+	assert.Nil(t, err)
+
+	admin, err := certificates.Authenticate(requestWithCertificate("reporting"))
+	assert.Nil(t, err)
+	assert.Equal(t, "cert:reporting", admin.Name)
+	assert.Equal(t, []string{ScopeRead, ScopeExport}, admin.Scopes)
+
+	_, err = certificates.Authenticate(requestWithCertificate("someone"))
+	assert.EqualError(t, err, "client certificate someone is not mapped to a role")
+
+	fromBrowser := requestWithCertificate("reporting")
+	fromBrowser.Header.Set("Origin", "https://evil.example.com")
+	_, err = certificates.Authenticate(fromBrowser)
+	assert.NotNil(t, err)
+
+	_, err = certificates.Authenticate(httptest.NewRequest(http.MethodGet, "/admin/feedback", nil))
+	assert.EqualError(t, err, "no verified client certificate")
+}
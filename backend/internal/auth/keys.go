@@ -0,0 +1,340 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"feedback/internal"
+	"fmt"
+	"github.com/golang-jwt/jwt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type SigningKey struct {
+	Id      string
+	Method  jwt.SigningMethod
+	Private interface{}
+	Public  interface{}
+	Retired bool
+	// RetiredUntil ends the grace period of a retired key, after which it is neither accepted nor published.
+	RetiredUntil time.Time
+}
+
+// SecretKeyId names JWT_SECRET in JWT_RETIRED_KEYS to give the time it was retired by JWT_KEYS.
+const SecretKeyId = "JWT_SECRET"
+
+type KeySet struct {
+	signing *SigningKey
+	byId    map[string]*SigningKey
+}
+
+type Jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type Jwks struct {
+	Keys []Jwk `json:"keys"`
+}
+
+// LoadKeySet reads the keys configured in JWT_KEYS and JWT_RETIRED_KEYS. Without JWT_KEYS, tokens are signed with
+// JWT_SECRET using HS256; otherwise JWT_SECRET, if set, is only accepted as a retired key. Retired keys are given with
+// the time they were retired, e.g. old=/keys/old.pem@2006-01-02T15:04:05Z or JWT_SECRET@2006-01-02T15:04:05Z, and are
+// accepted for JWT_KEY_GRACE_PERIOD afterwards.
+func LoadKeySet(config *internal.Configuration) (*KeySet, error) {
+	keySet := &KeySet{byId: map[string]*SigningKey{}}
+
+	if config.JwtSecret != "" {
+		secret := &SigningKey{
+			Method:  jwt.SigningMethodHS256,
+			Private: []byte(config.JwtSecret),
+			Public:  []byte(config.JwtSecret),
+			Retired: config.JwtKeys != "",
+		}
+		keySet.byId[""] = secret
+		keySet.signing = secret
+	}
+
+	if err := keySet.loadKeys(config.JwtKeys); err != nil {
+		return nil, err
+	}
+	if err := keySet.loadRetiredKeys(config.JwtRetiredKeys, config.JwtKeyGracePeriod); err != nil {
+		return nil, err
+	}
+	if secret, found := keySet.byId[""]; found && secret.Retired && secret.RetiredUntil.IsZero() {
+		return nil, fmt.Errorf("JWT_SECRET is retired by JWT_KEYS, so JWT_RETIRED_KEYS must contain %s@<time retired>", SecretKeyId)
+	}
+
+	if config.JwtKeys != "" {
+		signingKeyId := config.JwtSigningKeyId
+		if signingKeyId == "" {
+			signingKeyId = strings.SplitN(strings.Split(config.JwtKeys, ",")[0], "=", 2)[0]
+		}
+		signing, found := keySet.byId[strings.TrimSpace(signingKeyId)]
+		if !found || signing.Retired || signing.Private == nil {
+			return nil, fmt.Errorf("signing key %s is not an active private key", signingKeyId)
+		}
+		keySet.signing = signing
+	}
+
+	if keySet.signing == nil {
+		return nil, errors.New("neither JWT_SECRET nor JWT_KEYS are set")
+	}
+	return keySet, nil
+}
+
+// KeyCache keeps the key sets of configurations, so that key files are read once instead of on every request.
+type KeyCache struct {
+	mutex sync.Mutex
+	sets  map[keySettings]*KeySet
+}
+
+// keySettings are the settings a key set is loaded from, which differ between tenants.
+type keySettings struct {
+	secret       string
+	keys         string
+	retiredKeys  string
+	signingKeyId string
+}
+
+func NewKeyCache() *KeyCache {
+	return &KeyCache{sets: map[keySettings]*KeySet{}}
+}
+
+// Load returns the key set of a configuration, loading it on first use.
+func (cache *KeyCache) Load(config *internal.Configuration) (*KeySet, error) {
+	settings := keySettings{config.JwtSecret, config.JwtKeys, config.JwtRetiredKeys, config.JwtSigningKeyId}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if keySet, found := cache.sets[settings]; found {
+		return keySet, nil
+	}
+	keySet, err := LoadKeySet(config)
+	if err != nil {
+		return nil, err
+	}
+	cache.sets[settings] = keySet
+	return keySet, nil
+}
+
+// Clear drops all key sets, so that key files are read again, e.g. after the configuration has been reloaded.
+func (cache *KeyCache) Clear() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.sets = map[keySettings]*KeySet{}
+}
+
+func (keySet *KeySet) loadKeys(keyList string) error {
+	for _, entry := range strings.Split(keyList, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		if _, err := keySet.loadKey(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (keySet *KeySet) loadRetiredKeys(keyList string, gracePeriod time.Duration) error {
+	for _, entry := range strings.Split(keyList, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		separator := strings.LastIndex(entry, "@")
+		if separator < 0 {
+			return fmt.Errorf("retired key entry %s is not of the form kid=path@time", entry)
+		}
+		retiredAt, err := time.Parse(time.RFC3339, strings.TrimSpace(entry[separator+1:]))
+		if err != nil {
+			return fmt.Errorf("retired key entry %s has no RFC 3339 time it was retired at", entry)
+		}
+
+		var key *SigningKey
+		if strings.TrimSpace(entry[:separator]) == SecretKeyId {
+			if key = keySet.byId[""]; key == nil || !key.Retired {
+				return fmt.Errorf("%s is only retired if both JWT_SECRET and JWT_KEYS are set", SecretKeyId)
+			}
+		} else if key, err = keySet.loadKey(entry[:separator]); err != nil {
+			return err
+		}
+		key.Retired = true
+		key.RetiredUntil = retiredAt.Add(gracePeriod)
+	}
+	return nil
+}
+
+func (keySet *KeySet) loadKey(entry string) (*SigningKey, error) {
+	idAndPath := strings.SplitN(entry, "=", 2)
+	if len(idAndPath) != 2 || strings.TrimSpace(idAndPath[0]) == "" {
+		return nil, fmt.Errorf("key entry %s is not of the form kid=path", entry)
+	}
+	keyId := strings.TrimSpace(idAndPath[0])
+	if _, found := keySet.byId[keyId]; found {
+		return nil, fmt.Errorf("key id %s is configured twice", keyId)
+	}
+	key, err := readKey(keyId, strings.TrimSpace(idAndPath[1]))
+	if err != nil {
+		return nil, err
+	}
+	keySet.byId[keyId] = key
+	return key, nil
+}
+
+func readKey(keyId string, path string) (*SigningKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("key file %s contains no PEM data", path)
+	}
+
+	var private, public interface{}
+	if parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		private = parsed
+	} else if parsed, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		private = parsed
+	} else if parsed, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		private = parsed
+	} else if parsed, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		public = parsed
+	} else {
+		return nil, fmt.Errorf("key file %s contains no supported key", path)
+	}
+
+	if signer, ok := private.(crypto.Signer); ok {
+		public = signer.Public()
+	}
+
+	method, err := signingMethodFor(public)
+	if err != nil {
+		return nil, fmt.Errorf("key file %s: %w", path, err)
+	}
+	return &SigningKey{Id: keyId, Method: method, Private: private, Public: public}, nil
+}
+
+func signingMethodFor(public interface{}) (jwt.SigningMethod, error) {
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return jwt.SigningMethodES256, nil
+		case elliptic.P384():
+			return jwt.SigningMethodES384, nil
+		case elliptic.P521():
+			return jwt.SigningMethodES512, nil
+		}
+		return nil, errors.New("unsupported elliptic curve")
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, errors.New("unsupported key type")
+}
+
+func (keySet *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(keySet.signing.Method, claims)
+	if keySet.signing.Id != "" {
+		token.Header["kid"] = keySet.signing.Id
+	}
+	return token.SignedString(keySet.signing.Private)
+}
+
+// Lookup returns the key a token claims to be signed with, as long as its algorithm matches the key and it is not
+// retired for longer than the grace period. Whoever holds a retired key can issue tokens of any age, so the grace period
+// starts when the key was retired rather than when the token was issued.
+func (keySet *KeySet) Lookup(token *jwt.Token) (*SigningKey, error) {
+	keyId, _ := token.Header["kid"].(string)
+	key, found := keySet.byId[keyId]
+	if !found {
+		return nil, errors.New("unknown signing key: " + keyId)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, errors.New("unexpected signing method: " + token.Method.Alg())
+	}
+	if key.expired(time.Now()) {
+		return nil, ErrRetiredKey
+	}
+	return key, nil
+}
+
+func (key *SigningKey) expired(now time.Time) bool {
+	return key.Retired && !now.Before(key.RetiredUntil)
+}
+
+// Jwks returns the public parts of all asymmetric keys, including retired ones until their grace period has ended.
+func (keySet *KeySet) Jwks() Jwks {
+	jwks := Jwks{Keys: []Jwk{}}
+	now := time.Now()
+	for _, key := range keySet.byId {
+		if key.expired(now) {
+			continue
+		}
+		jwk := Jwk{Use: "sig", Alg: key.Method.Alg(), Kid: key.Id}
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = encodeBase64(public.N.Bytes())
+			jwk.E = encodeBase64(big.NewInt(int64(public.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (public.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = public.Curve.Params().Name
+			jwk.X = encodeBase64(public.X.FillBytes(make([]byte, size)))
+			jwk.Y = encodeBase64(public.Y.FillBytes(make([]byte, size)))
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = encodeBase64(public)
+		default:
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	sort.Slice(jwks.Keys, func(i, j int) bool {
+		return jwks.Keys[i].Kid < jwks.Keys[j].Kid
+	})
+	return jwks
+}
+
+func encodeBase64(value []byte) string {
+	return base64.RawURLEncoding.EncodeToString(value)
+}
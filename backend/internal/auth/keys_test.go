@@ -0,0 +1,161 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"feedback/internal"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeKey(t *testing.T, name string, key interface{}) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), name+".pem")
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func testConfiguration() *internal.Configuration {
+	return &internal.Configuration{
+		JwtIssuer:         "feedback-backend",
+		JwtAudience:       "feedback-backend",
+		JwtExpiry:         time.Hour,
+		JwtKeyGracePeriod: time.Hour,
+	}
+}
+
+func TestKeySet_SignAndVerifyAsymmetricKeys(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	for kid, expectedAlg := range map[string]string{
+		writeKey(t, "rsa", rsaKey): "RS256",
+		writeKey(t, "ec", ecKey):   "ES256",
+		writeKey(t, "ed", edKey):   "EdDSA",
+	} {
+		config := testConfiguration()
+		config.JwtKeys = "current=" + kid
+		authentication := New(config, nil)
+
+		tokenString, _, err := authentication.generate("@user:domain.tld", "")
+		assert.Nil(t, err)
+		token, _, _ := new(jwt.Parser).ParseUnverified(tokenString, &FeedbackClaims{})
+		assert.Equal(t, expectedAlg, token.Method.Alg())
+		assert.Equal(t, "current", token.Header["kid"])
+
+		claims, err := authentication.IsAuthorized(&tokenString)
+		assert.Nil(t, err)
+		assert.Equal(t, HashUserId("@user:domain.tld"), claims.Subject)
+
+		jwks := authentication.Jwks()
+		assert.Len(t, jwks.Keys, 1)
+		assert.Equal(t, "current", jwks.Keys[0].Kid)
+		assert.Equal(t, expectedAlg, jwks.Keys[0].Alg)
+	}
+}
+
+func TestKeySet_RetiredKeyGracePeriod(t *testing.T) {
+	oldKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	newKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	oldPath := writeKey(t, "old", oldKey)
+	newPath := writeKey(t, "new", newKey)
+
+	config := testConfiguration()
+	config.JwtKeys = "old=" + oldPath
+	oldToken, _, err := New(config, nil).generate("@user:domain.tld", "")
+	assert.Nil(t, err)
+
+	config.JwtKeys = "new=" + newPath
+	config.JwtRetiredKeys = "old=" + oldPath + "@" + time.Now().Add(-time.Minute).Format(time.RFC3339)
+	authentication := New(config, nil)
+
+	_, err = authentication.IsAuthorized(&oldToken)
+	assert.Nil(t, err)
+	assert.Len(t, authentication.Jwks().Keys, 2)
+
+	// the token was issued just now, but the key was retired before the grace period
+	config.JwtRetiredKeys = "old=" + oldPath + "@" + time.Now().Add(-2*time.Hour).Format(time.RFC3339)
+	authentication = New(config, nil)
+	_, err = authentication.IsAuthorized(&oldToken)
+	assert.Equal(t, ErrRetiredKey, err)
+	assert.Len(t, authentication.Jwks().Keys, 1)
+
+	config.JwtRetiredKeys = "old=" + oldPath
+	_, err = LoadKeySet(config)
+	assert.EqualError(t, err, "retired key entry old="+oldPath+" is not of the form kid=path@time")
+
+	config.JwtRetiredKeys = ""
+	_, err = New(config, nil).IsAuthorized(&oldToken)
+	assert.NotNil(t, err)
+}
+
+func TestKeySet_SecretIsRetiredOnceKeysAreConfigured(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	config := testConfiguration()
+	config.JwtSecret = "someArbitraryString"
+	hmacToken, _, err := New(config, nil).generate("@user:domain.tld", "")
+	assert.Nil(t, err)
+
+	config.JwtKeys = "current=" + writeKey(t, "ec", ecKey)
+	_, err = LoadKeySet(config)
+	assert.EqualError(t, err, "JWT_SECRET is retired by JWT_KEYS, so JWT_RETIRED_KEYS must contain JWT_SECRET@<time retired>")
+
+	config.JwtRetiredKeys = "JWT_SECRET@" + time.Now().Format(time.RFC3339)
+	authentication := New(config, nil)
+	_, err = authentication.IsAuthorized(&hmacToken)
+	assert.Nil(t, err)
+
+	tokenString, _, err := authentication.generate("@user:domain.tld", "")
+	assert.Nil(t, err)
+	token, _, _ := new(jwt.Parser).ParseUnverified(tokenString, &FeedbackClaims{})
+	assert.Equal(t, "ES256", token.Method.Alg())
+	assert.Len(t, authentication.Jwks().Keys, 1)
+}
+
+func TestKeySet_RejectsAlgorithmOfOtherKey(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	config := testConfiguration()
+	config.JwtKeys = "current=" + writeKey(t, "ec", ecKey)
+	authentication := New(config, nil)
+
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &FeedbackClaims{})
+	forged.Header["kid"] = "current"
+	tokenString, _ := forged.SignedString([]byte("anything"))
+
+	_, err := authentication.IsAuthorized(&tokenString)
+	assert.NotNil(t, err)
+}
@@ -18,11 +18,16 @@
 package auth
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"feedback/internal"
 	"feedback/internal/api"
 	"feedback/internal/client"
+	"feedback/internal/health"
 	"github.com/golang-jwt/jwt"
 	"io"
 	"net/http"
@@ -31,34 +36,76 @@ import (
 	"time"
 )
 
+const MeetingIdParameter = "meeting_id"
+
+var (
+	ErrUserNotValid = errors.New("user is not valid")
+	ErrTokenExpired = errors.New("token is either expired or not active yet")
+	ErrTokenRevoked = errors.New("token has been revoked")
+	ErrRetiredKey   = errors.New("token was signed with a retired key")
+)
+
 type OidcAuthentication struct {
-	config *internal.Configuration
+	config   *internal.Configuration
+	keys     *KeySet
+	denylist Denylist
 }
 
-func New(config *internal.Configuration) *OidcAuthentication {
-	return &OidcAuthentication{config}
+// Denylist tells whether a token of a tenant has been revoked by its ID, its subject or its issue time.
+type Denylist interface {
+	IsRevoked(tenant string, tokenId string, subject string, issuedAt time.Time) (bool, error)
 }
 
-func (auth OidcAuthentication) Validate(request *http.Request) (*string, error) {
+type FeedbackClaims struct {
+	jwt.StandardClaims
+	MeetingId string `json:"meeting_id,omitempty"`
+	Anonymous bool   `json:"anonymous,omitempty"`
+	Tenant    string `json:"tenant,omitempty"`
+}
+
+func New(config *internal.Configuration, denylist Denylist) *OidcAuthentication {
+	keys, err := LoadKeySet(config)
+	if err != nil {
+		panic(err)
+	}
+
+	return NewWithKeys(config, keys, denylist)
+}
+
+// NewWithKeys creates an authentication with keys that have already been loaded, e.g. by a KeyCache.
+func NewWithKeys(config *internal.Configuration, keys *KeySet, denylist Denylist) *OidcAuthentication {
+	return &OidcAuthentication{config, keys, denylist}
+}
+
+func (auth OidcAuthentication) Validate(request *http.Request) (*string, *FeedbackClaims, error) {
 	validationResponse, err := auth.validate(request)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if validationResponse.Results.User == true && len(validationResponse.UserId) > 0 {
-		token, err := auth.generate()
-		return &token, err
+		meetingId := request.URL.Query().Get(MeetingIdParameter)
+		token, claims, err := auth.generate(validationResponse.UserId, meetingId)
+		return &token, claims, err
 	} else {
-		return nil, errors.New("user is not valid")
+		return nil, nil, ErrUserNotValid
 	}
 }
 
-func (auth OidcAuthentication) IsAuthorized(tokenString *string) (bool, error) {
+func (auth OidcAuthentication) IsAuthorized(tokenString *string) (*FeedbackClaims, error) {
 	parsedJwt, err := auth.parseJwt(tokenString)
-	if err != nil {
-		return false, err
+	if _, err = auth.validateJwt(parsedJwt, err); err != nil {
+		return nil, err
 	}
 
-	return auth.validateJwt(parsedJwt, err)
+	claims := parsedJwt.Claims.(*FeedbackClaims)
+	if err = auth.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, auth.validateNotRevoked(claims)
+}
+
+func (auth OidcAuthentication) Jwks() Jwks {
+	return auth.keys.Jwks()
 }
 
 func (auth OidcAuthentication) validate(request *http.Request) (*api.ValidationResponse, error) {
@@ -67,7 +114,7 @@ func (auth OidcAuthentication) validate(request *http.Request) (*api.ValidationR
 		return nil, err
 	}
 
-	response, err := client.Post(auth.config, requestBody)
+	response, err := client.Post(request.Context(), auth.config, requestBody)
 	if err != nil {
 		return nil, err
 	}
@@ -103,15 +150,17 @@ func (auth OidcAuthentication) ExtractTokenFrom(request *http.Request) (*string,
 }
 
 func (auth OidcAuthentication) validateJwt(token *jwt.Token, err error) (bool, error) {
-	if token.Valid {
+	if token != nil && token.Valid {
 		return true, err
 	} else if ve, ok := err.(*jwt.ValidationError); ok {
 		if ve.Errors&jwt.ValidationErrorMalformed != 0 {
 			err = errors.New("token malformed")
 			return false, err
 		} else if ve.Errors&(jwt.ValidationErrorExpired|jwt.ValidationErrorNotValidYet) != 0 {
-			err = errors.New("token is either expired or not active yet")
+			err = ErrTokenExpired
 			return false, err
+		} else if ve.Inner == ErrRetiredKey {
+			return false, ErrRetiredKey
 		} else {
 			err = errors.New("couldn't handle this token: " + err.Error())
 			return false, err
@@ -125,9 +174,13 @@ func (auth OidcAuthentication) validateJwt(token *jwt.Token, err error) (bool, e
 func (auth OidcAuthentication) parseJwt(tokenString *string) (*jwt.Token, error) {
 	token, err := jwt.ParseWithClaims(
 		*tokenString,
-		&jwt.StandardClaims{},
+		&FeedbackClaims{},
 		func(token *jwt.Token) (interface{}, error) {
-			return []byte(auth.config.JwtSecret), nil
+			key, err := auth.keys.Lookup(token)
+			if err != nil {
+				return nil, err
+			}
+			return key.Public, nil
 		},
 	)
 	return token, err
@@ -148,11 +201,92 @@ func mapFrom(respBody io.ReadCloser) (*api.ValidationResponse, error) {
 	return validationResponse, err
 }
 
-func (auth OidcAuthentication) generate() (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"nbf": time.Now().Unix(),
-	})
-	tokenString, err := token.SignedString([]byte(auth.config.JwtSecret))
+func (auth OidcAuthentication) validateClaims(claims *FeedbackClaims) error {
+	if claims.ExpiresAt == 0 || claims.IssuedAt == 0 {
+		return errors.New("token has no expiry or issue time")
+	}
+	if claims.Subject == "" || claims.Id == "" {
+		return errors.New("token has no subject or id")
+	}
+	if !claims.VerifyIssuer(auth.config.JwtIssuer, true) {
+		return errors.New("token has an unexpected issuer")
+	}
+	if !claims.VerifyAudience(auth.config.JwtAudience, true) {
+		return errors.New("token has an unexpected audience")
+	}
+	if claims.Tenant != auth.config.TenantId {
+		return errors.New("token was issued for another tenant")
+	}
+	return nil
+}
+
+func (auth OidcAuthentication) validateNotRevoked(claims *FeedbackClaims) error {
+	if auth.denylist == nil {
+		return nil
+	}
+	revoked, err := auth.denylist.IsRevoked(claims.Tenant, claims.Id, claims.Subject, time.Unix(claims.IssuedAt, 0))
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return ErrTokenRevoked
+	}
+	return nil
+}
 
-	return tokenString, err
+// Mint issues a token to a Matrix user without asking the user verification service, e.g. to test a deployment.
+func (auth OidcAuthentication) Mint(userId string, meetingId string) (string, *FeedbackClaims, error) {
+	return auth.generate(userId, meetingId)
+}
+
+func (auth OidcAuthentication) generate(userId string, meetingId string) (string, *FeedbackClaims, error) {
+	tokenId, err := newTokenId()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return auth.sign(tokenId, HashUserId(userId), meetingId, false)
+}
+
+func (auth OidcAuthentication) sign(tokenId string, subject string, meetingId string, anonymous bool) (string, *FeedbackClaims, error) {
+	now := time.Now()
+	claims := &FeedbackClaims{
+		StandardClaims: jwt.StandardClaims{
+			Audience:  auth.config.JwtAudience,
+			ExpiresAt: now.Add(auth.config.JwtExpiry).Unix(),
+			Id:        tokenId,
+			IssuedAt:  now.Unix(),
+			Issuer:    auth.config.JwtIssuer,
+			NotBefore: now.Unix(),
+			Subject:   subject,
+		},
+		MeetingId: meetingId,
+		Anonymous: anonymous,
+		Tenant:    auth.config.TenantId,
+	}
+	tokenString, err := auth.keys.Sign(claims)
+
+	return tokenString, claims, err
+}
+
+// HashUserId derives the token subject from a Matrix user ID, so issued tokens don't carry the ID in plain text.
+func HashUserId(userId string) string {
+	sum := sha256.Sum256([]byte(userId))
+	return hex.EncodeToString(sum[:])
+}
+
+func newTokenId() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// RegisterHealthChecks registers whether the user verification service of the active configuration is reachable. It
+// is not critical, as feedback can still be submitted with JWTs issued before.
+func RegisterHealthChecks(registry *health.Registry, timeout time.Duration, config func() *internal.Configuration) {
+	registry.Register("uvs", timeout, false, func(ctx context.Context) error {
+		return client.CheckHealth(ctx, config())
+	})
 }
@@ -0,0 +1,42 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package auth
+
+import (
+	"context"
+	"feedback/internal"
+	"feedback/internal/health"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRegisterHealthChecks_FollowsActiveConfiguration(t *testing.T) {
+	uvs := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {}))
+	t.Cleanup(uvs.Close)
+	config := &internal.Configuration{OidcValidationUrl: "http://127.0.0.1:1/verify/user"}
+	checks := health.NewRegistry()
+	RegisterHealthChecks(checks, time.Second, func() *internal.Configuration { return config })
+
+	assert.Equal(t, health.StatusDown, checks.Run(context.Background()).Checks["uvs"].Status)
+
+	config = &internal.Configuration{OidcValidationUrl: uvs.URL + "/verify/user"}
+	assert.Equal(t, health.StatusUp, checks.Run(context.Background()).Checks["uvs"].Status)
+}
@@ -19,19 +19,59 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"feedback/internal"
+	"feedback/internal/metrics"
+	"feedback/internal/requestid"
+	"feedback/internal/tracing"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"time"
 )
 
-func Post(config *internal.Configuration, reqBody []byte) (io.ReadCloser, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", config.OidcValidationUrl, bytes.NewBuffer(reqBody))
+// Post calls the user verification service, traced as part of the request that caused the call.
+func Post(ctx context.Context, config *internal.Configuration, reqBody []byte) (io.ReadCloser, error) {
+	client := &http.Client{Transport: tracing.Transport("UVS", http.DefaultTransport)}
+	req, err := http.NewRequestWithContext(ctx, "POST", config.OidcValidationUrl, bytes.NewBuffer(reqBody))
 	req.Header.Add("Content-Type", "application/json")
+	if requestId := requestid.From(ctx); requestId != "" {
+		req.Header.Set(requestid.Header, requestId)
+	}
+	start := time.Now()
 	resp, err := client.Do(req)
+	metrics.UvsRequest(start, err == nil && resp.StatusCode < http.StatusInternalServerError)
 	if err != nil {
 		return nil, err
 	}
 
 	return resp.Body, err
 }
+
+// CheckHealth asks the user verification service for its health at UVS_HEALTH_URL, by default /health next to
+// OIDC_VALIDATION_URL.
+func CheckHealth(ctx context.Context, config *internal.Configuration) error {
+	healthUrl := config.UvsHealthUrl
+	if healthUrl == "" {
+		validationUrl, err := url.Parse(config.OidcValidationUrl)
+		if err != nil {
+			return err
+		}
+		healthUrl = (&url.URL{Scheme: validationUrl.Scheme, Host: validationUrl.Host, Path: "/health"}).String()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, healthUrl, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", healthUrl, resp.Status)
+	}
+	return nil
+}
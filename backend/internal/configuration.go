@@ -19,43 +19,552 @@ package internal
 
 import (
 	"fmt"
+	"github.com/BurntSushi/toml"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+	"io"
+	"math"
+	"net"
 	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
+const (
+	TokenPolicySingleUse = "single-use"
+	TokenPolicyEditable  = "editable"
+
+	RateLimitStoreMemory   = "memory"
+	RateLimitStoreDatabase = "database"
+
+	TracesExporterNone = "none"
+	TracesExporterOtlp = "otlp"
+
+	LogFormatConsole = "console"
+	LogFormatJson    = "json"
+
+	// ConfigFileVariable names the environment variable with the path of the configuration file.
+	ConfigFileVariable = "CONFIG_FILE"
+	// MaskedSecret replaces the values of secrets when the configuration is printed.
+	MaskedSecret = "********"
+)
+
+// RateLimit allows a number of requests per period, configured as e.g. "10/1h". A zero RateLimit is unlimited.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+}
+
+func (limit RateLimit) Enabled() bool {
+	return limit.Requests > 0 && limit.Per > 0
+}
+
+// String returns the limit in the form it is configured in.
+func (limit RateLimit) String() string {
+	if !limit.Enabled() {
+		return "0"
+	}
+	return fmt.Sprintf("%d/%s", limit.Requests, limit.Per)
+}
+
+// Configuration is read by LoadConfiguration. Every setting is named by its key in the configuration file (json), its
+// environment variable (env) and may have a default. Required settings must not be empty, secrets may be read from files
+// and are masked when the configuration is printed. Settings marked restart are only read at startup and don't take
+// effect when the configuration is reloaded.
 type Configuration struct {
-	DbHost            string `json:"db_host,localhost"`                                  // DB_HOST
-	DbPort            string `json:"db_port,5432"`                                       // DB_PORT
-	DbUser            string `json:"db_user,postgres"`                                   // DB_USER
-	DbPassword        string `json:"db_password,postgres"`                               // DB_PASSWORD
-	DbName            string `json:"db_name,postgres"`                                   // DB_NAME
-	Sslmode           string `json:"sslmode,disable"`                                    // SSL_MODE
-	OidcValidationUrl string `json:"oidc_validation_url,'https://some.url/verify/user'"` // OIDC_VALIDATION_URL
-	JwtSecret         string `json:"jwt_secret,someArbitraryString"`                     // JWT_SECRET
-	MatrixServerName  string `json:"matrix_server_name,'domain.tld'"`                    // MATRIX_SERVER_NAME
-}
-
-func ConfigurationFromEnv() *Configuration {
-	config := Configuration{
-		os.Getenv("DB_HOST"),
-		os.Getenv("DB_PORT"),
-		os.Getenv("DB_USER"),
-		os.Getenv("DB_PASSWORD"),
-		os.Getenv("DB_NAME"),
-		os.Getenv("SSL_MODE"),
-		os.Getenv("OIDC_VALIDATION_URL"),
-		os.Getenv("JWT_SECRET"),
-		os.Getenv("MATRIX_SERVER_NAME"),
+	DbHost                string            `json:"db_host" env:"DB_HOST" default:"localhost" restart:"true"`
+	DbPort                string            `json:"db_port" env:"DB_PORT" default:"5432" restart:"true"`
+	DbUser                string            `json:"db_user" env:"DB_USER" default:"postgres" restart:"true"`
+	DbPassword            string            `json:"db_password" env:"DB_PASSWORD" required:"true" secret:"true" restart:"true"`
+	DbName                string            `json:"db_name" env:"DB_NAME" default:"postgres" restart:"true"`
+	Sslmode               string            `json:"sslmode" env:"SSL_MODE" default:"disable" restart:"true"`
+	OidcValidationUrl     string            `json:"oidc_validation_url" env:"OIDC_VALIDATION_URL" required:"true"`
+	JwtSecret             string            `json:"jwt_secret" env:"JWT_SECRET" secret:"true"`
+	MatrixServerName      string            `json:"matrix_server_name" env:"MATRIX_SERVER_NAME" required:"true"`
+	JwtIssuer             string            `json:"jwt_issuer" env:"JWT_ISSUER" default:"feedback-backend"`
+	JwtAudience           string            `json:"jwt_audience" env:"JWT_AUDIENCE" default:"feedback-backend"`
+	JwtExpiry             time.Duration     `json:"jwt_expiry" env:"JWT_EXPIRY" default:"24h"`
+	JwtKeys               string            `json:"jwt_keys" env:"JWT_KEYS"`
+	JwtRetiredKeys        string            `json:"jwt_retired_keys" env:"JWT_RETIRED_KEYS"`
+	JwtSigningKeyId       string            `json:"jwt_signing_key_id" env:"JWT_SIGNING_KEY_ID"`
+	JwtKeyGracePeriod     time.Duration     `json:"jwt_key_grace_period" env:"JWT_KEY_GRACE_PERIOD"` // defaults to JwtExpiry
+	TokenPolicy           string            `json:"token_policy" env:"TOKEN_POLICY" default:"editable"`
+	AdminToken            string            `json:"admin_token" env:"ADMIN_TOKEN" secret:"true"`
+	AnonymousTokens       bool              `json:"anonymous_tokens" env:"ANONYMOUS_TOKENS" default:"false"`
+	PowDifficulty         int               `json:"pow_difficulty" env:"POW_DIFFICULTY" default:"20"`
+	AnonymousIpLimit      RateLimit         `json:"anonymous_ip_limit" env:"ANONYMOUS_IP_LIMIT" default:"10/1h"`
+	AnonymousMeetingLimit RateLimit         `json:"anonymous_meeting_limit" env:"ANONYMOUS_MEETING_LIMIT" default:"100/1h"`
+	RateLimitIp           RateLimit         `json:"rate_limit_ip" env:"RATE_LIMIT_IP" default:"60/1m"`
+	RateLimitSubject      RateLimit         `json:"rate_limit_subject" env:"RATE_LIMIT_SUBJECT" default:"10/1m"`
+	RateLimitMeeting      RateLimit         `json:"rate_limit_meeting" env:"RATE_LIMIT_MEETING" default:"600/1m"`
+	RateLimitStore        string            `json:"rate_limit_store" env:"RATE_LIMIT_STORE" default:"memory" restart:"true"`
+	TrustedProxies        []*net.IPNet      `json:"trusted_proxies" env:"TRUSTED_PROXIES"`
+	CorsAllowedOrigins    []string          `json:"cors_allowed_origins" env:"CORS_ALLOWED_ORIGINS" default:"*"`
+	CorsAllowedMethods    []string          `json:"cors_allowed_methods" env:"CORS_ALLOWED_METHODS" default:"GET,POST"`
+	CorsAllowedHeaders    []string          `json:"cors_allowed_headers" env:"CORS_ALLOWED_HEADERS" default:"Authorization,Content-Type"`
+	CorsMaxAge            time.Duration     `json:"cors_max_age" env:"CORS_MAX_AGE" default:"10m"`
+	CorsAllowCredentials  bool              `json:"cors_allow_credentials" env:"CORS_ALLOW_CREDENTIALS" default:"false"`
+	AdminOidcIssuer       string            `json:"admin_oidc_issuer" env:"ADMIN_OIDC_ISSUER" restart:"true"`
+	AdminOidcClientId     string            `json:"admin_oidc_client_id" env:"ADMIN_OIDC_CLIENT_ID" restart:"true"`
+	AdminOidcClientSecret string            `json:"admin_oidc_client_secret" env:"ADMIN_OIDC_CLIENT_SECRET" secret:"true" restart:"true"`
+	AdminOidcRedirectUrl  string            `json:"admin_oidc_redirect_url" env:"ADMIN_OIDC_REDIRECT_URL" restart:"true"`
+	AdminOidcGroupsClaim  string            `json:"admin_oidc_groups_claim" env:"ADMIN_OIDC_GROUPS_CLAIM" default:"groups" restart:"true"`
+	AdminOidcGroupRoles   map[string]string `json:"admin_oidc_group_roles" env:"ADMIN_OIDC_GROUP_ROLES" restart:"true"`
+	SessionSecret         string            `json:"session_secret" env:"SESSION_SECRET" secret:"true" restart:"true"`
+	SessionDuration       time.Duration     `json:"session_duration" env:"SESSION_DURATION" default:"8h" restart:"true"`
+	EncryptionKeys        string            `json:"encryption_keys" env:"ENCRYPTION_KEYS" secret:"true" restart:"true"`
+	EncryptionActiveKey   string            `json:"encryption_active_key" env:"ENCRYPTION_ACTIVE_KEY" restart:"true"`
+	EncryptedMetadata     []string          `json:"encrypted_metadata" env:"ENCRYPTED_METADATA" default:"matrixUserId,displayName" restart:"true"`
+	MinGroupSize          int               `json:"min_group_size" env:"MIN_GROUP_SIZE" default:"5"`
+	QuasiIdentifiers      []string          `json:"quasi_identifiers" env:"QUASI_IDENTIFIERS" default:"meetingId,appShard,userRegion"`
+	PrivacyBudget         float64           `json:"privacy_budget" env:"PRIVACY_BUDGET" default:"1"`
+	PrivacyBudgetWindow   time.Duration     `json:"privacy_budget_window" env:"PRIVACY_BUDGET_WINDOW" default:"24h"`
+	PrivacyEpsilon        float64           `json:"privacy_epsilon" env:"PRIVACY_EPSILON" default:"0.1"`
+	PrivacyDelta          float64           `json:"privacy_delta" env:"PRIVACY_DELTA" default:"1e-6"`
+	MetadataCategories    map[string]string `json:"metadata_categories" env:"METADATA_CATEGORIES"`
+	ConsentRequired       bool              `json:"consent_required" env:"CONSENT_REQUIRED" default:"false"`
+	HealthCheckTimeout    time.Duration     `json:"health_check_timeout" env:"HEALTH_CHECK_TIMEOUT" default:"2s" restart:"true"`
+	UvsHealthUrl          string            `json:"uvs_health_url" env:"UVS_HEALTH_URL" restart:"true"`
+	ListenAddress         string            `json:"listen_address" env:"LISTEN_ADDRESS" default:":8080" restart:"true"`
+	ReadTimeout           time.Duration     `json:"read_timeout" env:"READ_TIMEOUT" default:"10s" restart:"true"`
+	WriteTimeout          time.Duration     `json:"write_timeout" env:"WRITE_TIMEOUT" default:"5m" restart:"true"`
+	IdleTimeout           time.Duration     `json:"idle_timeout" env:"IDLE_TIMEOUT" default:"2m" restart:"true"`
+	MaxHeaderBytes        int               `json:"max_header_bytes" env:"MAX_HEADER_BYTES" default:"1048576" restart:"true"`
+	ShutdownTimeout       time.Duration     `json:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" default:"25s" restart:"true"`
+	TlsCertFile           string            `json:"tls_cert_file" env:"TLS_CERT_FILE" restart:"true"`
+	TlsKeyFile            string            `json:"tls_key_file" env:"TLS_KEY_FILE" restart:"true"`
+	TlsMinVersion         string            `json:"tls_min_version" env:"TLS_MIN_VERSION" default:"1.2" restart:"true"`
+	TlsCipherSuites       []string          `json:"tls_cipher_suites" env:"TLS_CIPHER_SUITES" restart:"true"`
+	TlsClientCaFile       string            `json:"tls_client_ca_file" env:"TLS_CLIENT_CA_FILE" restart:"true"`
+	TlsClientRoles        map[string]string `json:"tls_client_roles" env:"TLS_CLIENT_ROLES" restart:"true"`
+	HttpRedirectAddress   string            `json:"http_redirect_address" env:"HTTP_REDIRECT_ADDRESS" restart:"true"`
+	LogFormat             string            `json:"log_format" env:"LOG_FORMAT" default:"console" restart:"true"`
+	LogLevel              string            `json:"log_level" env:"LOG_LEVEL" default:"info" restart:"true"`
+	LogSampling           RateLimit         `json:"log_sampling" env:"LOG_SAMPLING" restart:"true"`
+	LogRedactedKeys       []string          `json:"log_redacted_keys" env:"LOG_REDACTED_KEYS" default:"matrixUserId,displayName" restart:"true"`
+	MetricsAddress        string            `json:"metrics_address" env:"METRICS_ADDRESS" default:":9090" restart:"true"`
+	TracesExporter        string            `json:"traces_exporter" env:"OTEL_TRACES_EXPORTER" default:"none" restart:"true"`
+	Survey                string            `json:"survey" env:"SURVEY"`
+	TenantsFile           string            `json:"tenants_file" env:"TENANTS_FILE" restart:"true"`
+	TenantId              string            `json:"-"` // set by tenant.Apply
+}
+
+// ConfigurationError lists every invalid setting, so that all of them can be fixed at once.
+type ConfigurationError struct {
+	Problems []string
+}
+
+func (err *ConfigurationError) Error() string {
+	return "invalid configuration: " + strings.Join(err.Problems, "; ")
+}
+
+// LoadConfiguration reads the YAML or TOML file named in CONFIG_FILE, if set, and overrides its settings with the
+// environment variables. Secrets may also be read from the file named in <VARIABLE>_FILE or <key>_file, e.g. a mounted
+// Kubernetes secret. Settings that are set nowhere get their default.
+func LoadConfiguration() (*Configuration, error) {
+	return loadConfiguration(true)
+}
+
+// LoadDatabaseConfiguration loads the configuration like LoadConfiguration for commands that only work on the
+// database, e.g. migrations. Settings that are only required to serve the API may be missing.
+func LoadDatabaseConfiguration() (*Configuration, error) {
+	return loadConfiguration(false)
+}
+
+func loadConfiguration(serving bool) (*Configuration, error) {
+	path := os.Getenv(ConfigFileVariable)
+	file := map[string]string{}
+	if path != "" {
+		var err error
+		if file, err = readConfigurationFile(path); err != nil {
+			return nil, err
+		}
 	}
 
+	var problems []string
+	config := Configuration{}
 	elements := reflect.ValueOf(&config).Elem()
+	known := map[string]bool{}
+	for i := 0; i < elements.NumField(); i++ {
+		field := elements.Type().Field(i)
+		key := field.Tag.Get("json")
+		if key == "-" {
+			continue
+		}
+		known[key] = true
+		known[key+"_file"] = field.Tag.Get("secret") == "true"
+
+		value, source, err := lookupSetting(field, file, path)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		if value == "" {
+			if field.Tag.Get("required") == "true" && (serving || strings.HasPrefix(field.Tag.Get("env"), "DB_")) {
+				problems = append(problems, fmt.Sprintf("%s (%s) is not set", field.Tag.Get("env"), key))
+			}
+			continue
+		}
+		if err = parseSetting(elements.Field(i), value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", source, err))
+		}
+	}
+	keys := make([]string, 0, len(file))
+	for key := range file {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !known[key] {
+			problems = append(problems, fmt.Sprintf("%s: unknown setting %s", path, key))
+		}
+	}
+
+	if config.JwtKeyGracePeriod == 0 {
+		config.JwtKeyGracePeriod = config.JwtExpiry
+	}
+	problems = append(problems, config.validateLogging()...)
+	if serving {
+		problems = append(problems, config.validate()...)
+	}
+	if len(problems) > 0 {
+		return nil, &ConfigurationError{problems}
+	}
+	return &config, nil
+}
+
+// MustLoadConfiguration loads the configuration and panics if it is invalid.
+func MustLoadConfiguration() *Configuration {
+	config, err := LoadConfiguration()
+	if err != nil {
+		panic(err)
+	}
+	return config
+}
+
+// Changes returns the keys of the settings that differ in another configuration, and of these the ones that only take
+// effect after a restart.
+func (config *Configuration) Changes(other *Configuration) ([]string, []string) {
+	var changed, restart []string
+	elements, others := reflect.ValueOf(config).Elem(), reflect.ValueOf(other).Elem()
+	for i := 0; i < elements.NumField(); i++ {
+		field := elements.Type().Field(i)
+		key := field.Tag.Get("json")
+		if key == "-" || reflect.DeepEqual(elements.Field(i).Interface(), others.Field(i).Interface()) {
+			continue
+		}
+		changed = append(changed, key)
+		if field.Tag.Get("restart") == "true" {
+			restart = append(restart, key)
+		}
+	}
+	return changed, restart
+}
+
+// validate checks the settings that are limited to a few values or depend on each other.
+func (config *Configuration) validate() []string {
+	var problems []string
+	if config.JwtSecret == "" && config.JwtKeys == "" {
+		problems = append(problems, "neither JWT_SECRET nor JWT_KEYS is set")
+	}
+	if config.TokenPolicy != TokenPolicySingleUse && config.TokenPolicy != TokenPolicyEditable {
+		problems = append(problems, fmt.Sprintf("TOKEN_POLICY %s is neither %s nor %s", config.TokenPolicy, TokenPolicySingleUse, TokenPolicyEditable))
+	}
+	if config.AdminOidcIssuer != "" && (config.AdminOidcClientId == "" || config.AdminOidcRedirectUrl == "" || config.SessionSecret == "") {
+		problems = append(problems, "ADMIN_OIDC_ISSUER requires ADMIN_OIDC_CLIENT_ID, ADMIN_OIDC_REDIRECT_URL and SESSION_SECRET")
+	}
+	if config.RateLimitStore != RateLimitStoreMemory && config.RateLimitStore != RateLimitStoreDatabase {
+		problems = append(problems, fmt.Sprintf("RATE_LIMIT_STORE %s is neither %s nor %s", config.RateLimitStore, RateLimitStoreMemory, RateLimitStoreDatabase))
+	}
+	if config.PrivacyDelta >= 1 {
+		problems = append(problems, fmt.Sprintf("PRIVACY_DELTA %g is not below 1", config.PrivacyDelta))
+	}
+	if (config.TlsCertFile == "") != (config.TlsKeyFile == "") {
+		problems = append(problems, "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if config.TlsCertFile == "" && (config.TlsClientCaFile != "" || config.HttpRedirectAddress != "") {
+		problems = append(problems, "TLS_CLIENT_CA_FILE and HTTP_REDIRECT_ADDRESS require TLS_CERT_FILE")
+	}
+	for _, origin := range config.CorsAllowedOrigins {
+		if origin == "*" && config.CorsAllowCredentials {
+			problems = append(problems, "CORS_ALLOW_CREDENTIALS requires CORS_ALLOWED_ORIGINS without *")
+		}
+	}
+	if config.TracesExporter != TracesExporterNone && config.TracesExporter != TracesExporterOtlp {
+		problems = append(problems, fmt.Sprintf("OTEL_TRACES_EXPORTER %s is neither %s nor %s", config.TracesExporter, TracesExporterNone, TracesExporterOtlp))
+	}
+	return problems
+}
+
+// validateLogging checks the settings of the logger, which every command is logged with.
+func (config *Configuration) validateLogging() []string {
+	var problems []string
+	if config.LogFormat != LogFormatConsole && config.LogFormat != LogFormatJson {
+		problems = append(problems, fmt.Sprintf("LOG_FORMAT %s is neither %s nor %s", config.LogFormat, LogFormatConsole, LogFormatJson))
+	}
+	if _, err := zapcore.ParseLevel(config.LogLevel); err != nil {
+		problems = append(problems, fmt.Sprintf("LOG_LEVEL %s is not a valid level", config.LogLevel))
+	}
+	return problems
+}
 
+// lookupSetting returns the value of a setting and where it was taken from. Environment variables take precedence over
+// the configuration file, which takes precedence over the default. Empty values count as not set.
+func lookupSetting(field reflect.StructField, file map[string]string, path string) (string, string, error) {
+	name, key := field.Tag.Get("env"), field.Tag.Get("json")
+	secret := field.Tag.Get("secret") == "true"
+
+	if secret && os.Getenv(name+"_FILE") != "" {
+		if os.Getenv(name) != "" {
+			return "", "", fmt.Errorf("%s and %s_FILE are both set", name, name)
+		}
+		return readSecretFile(name+"_FILE", os.Getenv(name+"_FILE"))
+	}
+	if value := os.Getenv(name); value != "" {
+		return value, name, nil
+	}
+	if secret && file[key+"_file"] != "" {
+		if file[key] != "" {
+			return "", "", fmt.Errorf("%s: %s and %s_file are both set", path, key, key)
+		}
+		return readSecretFile(fmt.Sprintf("%s_file in %s", key, path), file[key+"_file"])
+	}
+	if value := file[key]; value != "" {
+		return value, fmt.Sprintf("%s in %s", key, path), nil
+	}
+	return field.Tag.Get("default"), "default of " + key, nil
+}
+
+// readSecretFile reads a secret without the line break that editors and `echo` add.
+func readSecretFile(source string, path string) (string, string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", source, err)
+	}
+	return strings.TrimRight(string(content), "\r\n"), source, nil
+}
+
+// readConfigurationFile reads the settings of a YAML or TOML file by key.
+func readConfigurationFile(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	settings := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &settings)
+	case ".toml":
+		err = toml.Unmarshal(content, &settings)
+	default:
+		return nil, fmt.Errorf("configuration file %s is neither .yaml, .yml nor .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("configuration file %s: %w", path, err)
+	}
+	file := make(map[string]string, len(settings))
+	for key, value := range settings {
+		file[key] = formatSetting(value)
+	}
+	return file, nil
+}
+
+// formatSetting turns a value of the configuration file into the form of an environment variable, so that both are
+// parsed alike: lists are separated by commas and maps become lists of key=value pairs.
+func formatSetting(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		entries := make([]string, len(value))
+		for i, entry := range value {
+			entries[i] = formatSetting(entry)
+		}
+		return strings.Join(entries, ",")
+	case map[string]interface{}:
+		entries := make([]string, 0, len(value))
+		for key, entry := range value {
+			entries = append(entries, key+"="+formatSetting(entry))
+		}
+		sort.Strings(entries)
+		return strings.Join(entries, ",")
+	}
+	return fmt.Sprint(value)
+}
+
+func parseSetting(target reflect.Value, value string) error {
+	var parsed interface{}
+	var err error
+	switch target.Interface().(type) {
+	case string:
+		parsed = value
+	case bool:
+		parsed, err = parseBool(value)
+	case int:
+		parsed, err = parseInt(value)
+	case float64:
+		parsed, err = parseFloat(value)
+	case time.Duration:
+		parsed, err = parseDuration(value)
+	case RateLimit:
+		parsed, err = ParseRateLimit(value)
+	case []string:
+		parsed = parseList(value)
+	case map[string]string:
+		parsed, err = ParseMap(value)
+	case []*net.IPNet:
+		parsed, err = parseNetworks(value)
+	default:
+		return fmt.Errorf("settings of type %s are not supported", target.Type())
+	}
+	if err != nil {
+		return err
+	}
+	target.Set(reflect.ValueOf(parsed))
+	return nil
+}
+
+// Print writes the configuration in the format of a YAML configuration file, with secrets masked.
+func (config *Configuration) Print(writer io.Writer) error {
+	document := &yaml.Node{Kind: yaml.MappingNode}
+	elements := reflect.ValueOf(config).Elem()
 	for i := 0; i < elements.NumField(); i++ {
-		varValue := elements.Field(i).Interface()
-		if varValue == "" {
-			panic(fmt.Sprintf("%s not set.", elements.Type().Field(i).Name))
+		field := elements.Type().Field(i)
+		key := field.Tag.Get("json")
+		if key == "-" {
+			continue
+		}
+		value := &yaml.Node{}
+		if err := value.Encode(printableSetting(elements.Field(i).Interface(), field.Tag.Get("secret") == "true")); err != nil {
+			return err
+		}
+		document.Content = append(document.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+	}
+	encoder := yaml.NewEncoder(writer)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(document); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// printableSetting returns a value in the form it is configured in.
+func printableSetting(value interface{}, secret bool) interface{} {
+	switch value := value.(type) {
+	case string:
+		if secret && value != "" {
+			return MaskedSecret
+		}
+	case time.Duration:
+		return value.String()
+	case RateLimit:
+		return value.String()
+	case []*net.IPNet:
+		networks := make([]string, len(value))
+		for i, network := range value {
+			networks[i] = network.String()
+		}
+		return networks
+	}
+	return value
+}
+
+func parseDuration(value string) (time.Duration, error) {
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("%s is not a valid positive duration", value)
+	}
+	return duration, nil
+}
+
+func parseBool(value string) (bool, error) {
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s is not a valid boolean", value)
+	}
+	return parsed, nil
+}
+
+func parseInt(value string) (int, error) {
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("%s is not a valid non-negative integer", value)
+	}
+	return parsed, nil
+}
+
+func parseFloat(value string) (float64, error) {
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || parsed < 0 || math.IsInf(parsed, 0) || math.IsNaN(parsed) {
+		return 0, fmt.Errorf("%s is not a valid non-negative number", value)
+	}
+	return parsed, nil
+}
+
+// parseList parses a comma separated list.
+func parseList(value string) []string {
+	var list []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+// ParseMap parses a comma separated list of key=value pairs.
+func ParseMap(value string) (map[string]string, error) {
+	entries := map[string]string{}
+	for _, entry := range parseList(value) {
+		keyAndValue := strings.SplitN(entry, "=", 2)
+		if len(keyAndValue) != 2 || keyAndValue[0] == "" {
+			return nil, fmt.Errorf("%s is not of the form key=value", entry)
+		}
+		entries[strings.TrimSpace(keyAndValue[0])] = strings.TrimSpace(keyAndValue[1])
+	}
+	return entries, nil
+}
+
+// parseNetworks parses a comma separated list of CIDRs or single addresses.
+func parseNetworks(value string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range parseList(value) {
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
 		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a valid network", entry)
+		}
+		networks = append(networks, network)
 	}
+	return networks, nil
+}
 
-	return &config
+// ParseRateLimit parses "<requests>/<duration>", e.g. "10/1h". "0" disables the limit.
+func ParseRateLimit(value string) (RateLimit, error) {
+	if value == "0" {
+		return RateLimit{}, nil
+	}
+	requestsAndPer := strings.SplitN(value, "/", 2)
+	if len(requestsAndPer) != 2 {
+		return RateLimit{}, fmt.Errorf("rate limit %s is not of the form requests/duration", value)
+	}
+	requests, err := strconv.Atoi(requestsAndPer[0])
+	if err != nil || requests <= 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %s has no positive number of requests", value)
+	}
+	per, err := time.ParseDuration(requestsAndPer[1])
+	if err != nil || per <= 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %s has no positive duration", value)
+	}
+	return RateLimit{requests, per}, nil
 }
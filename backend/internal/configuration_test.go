@@ -0,0 +1,164 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package internal
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// unsetEnv clears the settings of the environment the tests run in.
+func unsetEnv(t *testing.T) {
+	for _, name := range []string{"DB_HOST", "DB_PORT", "DB_USER", "DB_PASSWORD", "DB_NAME", "SSL_MODE", "JWT_SECRET",
+		"OIDC_VALIDATION_URL", "MATRIX_SERVER_NAME", "LOG_FORMAT", "LOG_LEVEL", ConfigFileVariable} {
+		t.Setenv(name, "")
+	}
+}
+
+func TestLoadConfiguration_FileEnvironmentAndDefaults(t *testing.T) {
+	unsetEnv(t)
+	t.Setenv(ConfigFileVariable, writeFile(t, "feedback.yaml", `
+oidc_validation_url: https://uvs.domain.tld/verify/user
+matrix_server_name: domain.tld
+db_password_file: `+writeFile(t, "password", "somePassphrase\n")+`
+jwt_secret: someArbitraryString
+jwt_expiry: 1h
+rate_limit_ip: 0
+cors_allowed_origins: [https://meet.domain.tld, "https://*.domain.tld"]
+admin_oidc_group_roles:
+  feedback-admins: admin
+log_format: json
+log_sampling: 100/1s
+`))
+	t.Setenv("MATRIX_SERVER_NAME", "other.tld")
+
+	config, err := LoadConfiguration()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "https://uvs.domain.tld/verify/user", config.OidcValidationUrl)
+	assert.Equal(t, "other.tld", config.MatrixServerName)
+	assert.Equal(t, "somePassphrase", config.DbPassword)
+	assert.Equal(t, time.Hour, config.JwtExpiry)
+	assert.Equal(t, time.Hour, config.JwtKeyGracePeriod)
+	assert.False(t, config.RateLimitIp.Enabled())
+	assert.Equal(t, []string{"https://meet.domain.tld", "https://*.domain.tld"}, config.CorsAllowedOrigins)
+	assert.Equal(t, map[string]string{"feedback-admins": "admin"}, config.AdminOidcGroupRoles)
+	assert.Equal(t, "localhost", config.DbHost)
+	assert.Equal(t, RateLimit{10, time.Hour}, config.AnonymousIpLimit)
+	assert.Equal(t, 1e-6, config.PrivacyDelta)
+	assert.Equal(t, LogFormatJson, config.LogFormat)
+	assert.Equal(t, "info", config.LogLevel)
+	assert.Equal(t, RateLimit{100, time.Second}, config.LogSampling)
+	assert.Equal(t, []string{"matrixUserId", "displayName"}, config.LogRedactedKeys)
+}
+
+func TestLoadConfiguration_Toml(t *testing.T) {
+	unsetEnv(t)
+	t.Setenv(ConfigFileVariable, writeFile(t, "feedback.toml", `
+oidc_validation_url = "https://uvs.domain.tld/verify/user"
+matrix_server_name = "domain.tld"
+db_password = "somePassphrase"
+jwt_secret = "someArbitraryString"
+min_group_size = 10
+trusted_proxies = ["10.0.0.0/8"]
+`))
+
+	config, err := LoadConfiguration()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 10, config.MinGroupSize)
+	assert.Equal(t, "10.0.0.0/8", config.TrustedProxies[0].String())
+}
+
+func TestLoadConfiguration_ReportsAllProblems(t *testing.T) {
+	unsetEnv(t)
+	t.Setenv(ConfigFileVariable, writeFile(t, "feedback.yaml", "jwt_expiry: -1h\nunknown: value\n"))
+	t.Setenv("DB_PASSWORD", "somePassphrase")
+	t.Setenv("DB_PASSWORD_FILE", "/run/secrets/db-password")
+	t.Setenv("TOKEN_POLICY", "unknown")
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "true")
+	t.Setenv("LOG_LEVEL", "verbose")
+
+	_, err := LoadConfiguration()
+
+	assert.IsType(t, &ConfigurationError{}, err)
+	assert.ElementsMatch(t, []string{
+		"DB_PASSWORD and DB_PASSWORD_FILE are both set",
+		"OIDC_VALIDATION_URL (oidc_validation_url) is not set",
+		"MATRIX_SERVER_NAME (matrix_server_name) is not set",
+		"jwt_expiry in " + os.Getenv(ConfigFileVariable) + ": -1h is not a valid positive duration",
+		os.Getenv(ConfigFileVariable) + ": unknown setting unknown",
+		"neither JWT_SECRET nor JWT_KEYS is set",
+		"TOKEN_POLICY unknown is neither single-use nor editable",
+		"CORS_ALLOW_CREDENTIALS requires CORS_ALLOWED_ORIGINS without *",
+		"LOG_LEVEL verbose is not a valid level",
+	}, err.(*ConfigurationError).Problems)
+}
+
+func TestLoadDatabaseConfiguration_OnlyRequiresDatabaseSettings(t *testing.T) {
+	unsetEnv(t)
+	t.Setenv("JWT_EXPIRY", "1h")
+
+	_, err := LoadDatabaseConfiguration()
+	assert.EqualError(t, err, "invalid configuration: DB_PASSWORD (db_password) is not set")
+
+	t.Setenv("DB_PASSWORD", "somePassphrase")
+	config, err := LoadDatabaseConfiguration()
+	assert.Nil(t, err)
+	assert.Equal(t, "somePassphrase", config.DbPassword)
+	assert.Equal(t, time.Hour, config.JwtExpiry)
+
+	_, err = LoadConfiguration()
+	assert.NotNil(t, err)
+}
+
+func TestConfiguration_PrintMasksSecrets(t *testing.T) {
+	config := &Configuration{DbPassword: "somePassphrase", RateLimitIp: RateLimit{60, time.Minute}}
+	var printed bytes.Buffer
+
+	assert.Nil(t, config.Print(&printed))
+
+	assert.NotContains(t, printed.String(), "somePassphrase")
+	assert.Contains(t, printed.String(), "db_password: '"+MaskedSecret+"'\n")
+	assert.Contains(t, printed.String(), "rate_limit_ip: 60/1m0s\n")
+	assert.Contains(t, printed.String(), "admin_token: \"\"\n")
+}
+
+func TestConfiguration_Changes(t *testing.T) {
+	config := &Configuration{Survey: "post-call-2023", ListenAddress: ":8080", CorsAllowedOrigins: []string{"*"}}
+	other := *config
+	other.Survey = "post-call-2024"
+	other.ListenAddress = ":8443"
+
+	changed, restart := config.Changes(&other)
+
+	assert.Equal(t, []string{"listen_address", "survey"}, changed)
+	assert.Equal(t, []string{"listen_address"}, restart)
+}
@@ -0,0 +1,55 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package consent
+
+import (
+	"feedback/internal/api"
+	"sort"
+)
+
+// Strip returns a copy of metadata without the keys whose category, as mapped by categories, the user did not consent
+// to. Keys without a category need no consent. Feedback without consent keeps all metadata, unless consent is
+// required.
+func Strip(metadata map[string]interface{}, given *api.Consent, categories map[string]string, required bool) map[string]interface{} {
+	stripped := make(map[string]interface{}, len(metadata))
+	for key, value := range metadata {
+		category, categorized := categories[key]
+		if categorized && (given != nil || required) && !given.Includes(category) {
+			continue
+		}
+		stripped[key] = value
+	}
+	return stripped
+}
+
+// Normalize returns the categories of the consent sorted and without duplicates, or nil if no consent was given.
+func Normalize(given *api.Consent) []string {
+	if given == nil {
+		return nil
+	}
+	unique := map[string]bool{}
+	normalized := []string{}
+	for _, category := range given.Categories {
+		if category != "" && !unique[category] {
+			unique[category] = true
+			normalized = append(normalized, category)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized
+}
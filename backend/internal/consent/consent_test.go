@@ -0,0 +1,42 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package consent
+
+import (
+	"feedback/internal/api"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestStrip(t *testing.T) {
+	metadata := map[string]interface{}{"displayName": "someName", "browserName": "firefox", "appShard": "a"}
+	categories := map[string]string{"displayName": "identity", "browserName": "technical"}
+
+	assert.Equal(t, metadata, Strip(metadata, nil, categories, false))
+	assert.Equal(t, map[string]interface{}{"appShard": "a"}, Strip(metadata, nil, categories, true))
+	assert.Equal(t, map[string]interface{}{"appShard": "a"}, Strip(metadata, &api.Consent{}, categories, false))
+	assert.Equal(t, map[string]interface{}{"browserName": "firefox", "appShard": "a"},
+		Strip(metadata, &api.Consent{Categories: []string{"technical"}}, categories, true))
+	assert.Len(t, metadata, 3)
+}
+
+func TestNormalize(t *testing.T) {
+	assert.Nil(t, Normalize(nil))
+	assert.Equal(t, []string{}, Normalize(&api.Consent{}))
+	assert.Equal(t, []string{"identity", "technical"}, Normalize(&api.Consent{Categories: []string{"technical", "", "identity", "technical"}}))
+}
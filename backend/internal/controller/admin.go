@@ -0,0 +1,412 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package controller
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"feedback/internal"
+	"feedback/internal/api"
+	"feedback/internal/auth"
+	"feedback/internal/consent"
+	"feedback/internal/repository"
+	"feedback/internal/statistics"
+	"feedback/internal/tenant"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
+// requireScope only passes requests on to next whose admin has been granted scope and may access the tenant of the
+// request. The admin is added to the request context.
+func (c *Controller) requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		admin, err := c.authenticateAdmin(request)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusUnauthorized)
+			logFor(request).Debug(err)
+			return
+		}
+		if !admin.HasScope(scope) {
+			http.Error(writer, "scope "+scope+" is required", http.StatusForbidden)
+			logFor(request).Debugw("admin lacks scope", "admin", admin.Name, "scope", scope)
+			return
+		}
+		if tenantId := tenant.IdFrom(request.Context()); !admin.MayAccess(tenantId) {
+			http.Error(writer, "tenant "+tenantId+" is not accessible", http.StatusForbidden)
+			logFor(request).Debugw("admin may not access tenant", "admin", admin.Name, "tenant", tenantId)
+			return
+		}
+		next(writer, request.WithContext(auth.WithAdmin(request.Context(), admin)))
+	}
+}
+
+// UseClientCertificates lets administrators authenticate with the client certificates verified against
+// TLS_CLIENT_CA_FILE.
+func (c *Controller) UseClientCertificates(certificates *auth.ClientCertificates) {
+	c.clientCertificates = certificates
+}
+
+// authenticateAdmin accepts a bearer token or, if enabled, a client certificate or the session cookie of admin login.
+func (c *Controller) authenticateAdmin(request *http.Request) (*auth.Admin, error) {
+	if c.clientCertificates != nil && request.Header.Get("Authorization") == "" && auth.HasClientCertificate(request) {
+		return c.clientCertificates.Authenticate(request)
+	}
+	if c.adminLogin != nil && request.Header.Get("Authorization") == "" {
+		admin, _, err := c.adminLogin.Authenticate(request)
+		return admin, err
+	}
+	authentication, err := c.authentication(request, c.configuration(request))
+	if err != nil {
+		return nil, err
+	}
+	return authentication.AuthorizeAdmin(request, c.repoFor(request))
+}
+
+func (c *Controller) createRevocation(writer http.ResponseWriter, request *http.Request) {
+	config := c.configuration(request)
+
+	var revocation api.Revocation
+	body, err := io.ReadAll(request.Body)
+	if err == nil {
+		err = json.Unmarshal(body, &revocation)
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		logFor(request).Debug(err)
+		return
+	}
+
+	revocationModel, err := repository.MapToRevocationModel(revocation, config.JwtExpiry, config.TenantId)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		logFor(request).Debug(err)
+		return
+	}
+
+	var revoked int64
+	err = c.repoFor(request).Transaction(func(repo repository.Interface) error {
+		revoked, err = repo.Revoke(revocationModel)
+		if err != nil {
+			return err
+		}
+		return audit(repo, request, repository.AuditTokensRevoke, pseudonymize(revocation), revoked)
+	})
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		logFor(request).Debug(err)
+		return
+	}
+	logFor(request).Infow("revoked tokens", "admin", auth.AdminFrom(request.Context()).Name, "revoked", revoked)
+
+	writer.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(writer).Encode(api.RevocationResponse{RevokedTokens: revoked})
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// getFeedbacks returns a page of the selected feedback, with the metadata stripped to the given consent. As in
+// exports, QUASI_IDENTIFIERS are suppressed for rows whose group among all selected feedback, not only the page, is
+// smaller than MIN_GROUP_SIZE.
+func (c *Controller) getFeedbacks(writer http.ResponseWriter, request *http.Request) {
+	config := c.configuration(request)
+	filter, err := parseFeedbackFilter(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		logFor(request).Debug(err)
+		return
+	}
+
+	groups, err := countQuasiIdentifiers(c.repoFor(request), filter, config)
+	var feedbacks []repository.Feedback
+	if err == nil {
+		feedbacks, err = c.repoFor(request).FindFeedbacks(filter)
+	}
+	if err == nil {
+		err = audit(c.repoFor(request), request, repository.AuditFeedbackRead, request.URL.Query(), int64(len(feedbacks)))
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		logFor(request).Debug(err)
+		return
+	}
+
+	response := make([]api.StoredFeedback, 0, len(feedbacks))
+	for _, feedback := range feedbacks {
+		feedback.Metadata = consentedMetadata(feedback, config)
+		suppressed := groups.Size(feedback.Metadata) < config.MinGroupSize
+		if suppressed {
+			feedback.Metadata = statistics.Suppress(feedback.Metadata, config.QuasiIdentifiers)
+		}
+		stored := repository.MapToStoredFeedback(feedback)
+		stored.Suppressed = suppressed
+		response = append(response, stored)
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(writer).Encode(response)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// exportFeedbacks streams the selected feedback as CSV, with the metadata as a JSON column. Rows whose
+// QUASI_IDENTIFIERS are shared by fewer than MIN_GROUP_SIZE rows of the export are exported without them.
+func (c *Controller) exportFeedbacks(writer http.ResponseWriter, request *http.Request) {
+	config := c.configuration(request)
+	filter, err := parseFeedbackFilter(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		logFor(request).Debug(err)
+		return
+	}
+
+	// the export is recorded with the size of the selection before any feedback leaves the server
+	groups, err := countQuasiIdentifiers(c.repoFor(request), filter, config)
+	if err == nil {
+		err = audit(c.repoFor(request), request, repository.AuditFeedbackExport, request.URL.Query(), int64(groups.Count()))
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		logFor(request).Debug(err)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/csv")
+	writer.Header().Set("Content-Disposition", `attachment; filename="feedback.csv"`)
+	csvWriter := csv.NewWriter(writer)
+	err = csvWriter.Write([]string{"id", "created_at", "rating", "rating_comment", "anonymous", "metadata", "suppressed", "consent"})
+	if err != nil {
+		logFor(request).Debug(err)
+		return
+	}
+
+	err = c.repoFor(request).ExportFeedbacks(filter, func(batch []repository.Feedback) error {
+		for _, feedback := range batch {
+			feedback.Metadata = consentedMetadata(feedback, config)
+			suppressed := groups.Size(feedback.Metadata) < config.MinGroupSize
+			if suppressed {
+				feedback.Metadata = statistics.Suppress(feedback.Metadata, config.QuasiIdentifiers)
+			}
+			metadata, err := json.Marshal(feedback.Metadata)
+			if err != nil {
+				return err
+			}
+			var consentedCategories []byte
+			if feedback.Consent != nil {
+				consentedCategories, _ = json.Marshal(feedback.Consent)
+			}
+			err = csvWriter.Write([]string{
+				strconv.FormatUint(uint64(feedback.ID), 10),
+				feedback.CreatedAt.UTC().Format(time.RFC3339),
+				strconv.Itoa(feedback.Rating),
+				feedback.RatingComment,
+				strconv.FormatBool(feedback.Anonymous),
+				string(metadata),
+				strconv.FormatBool(suppressed),
+				string(consentedCategories),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		csvWriter.Flush()
+		return csvWriter.Error()
+	})
+	if err != nil {
+		// the status has already been sent with the header row, so the export can only be cut short
+		logFor(request).Errorw("export failed", "error", err)
+		return
+	}
+	csvWriter.Flush()
+}
+
+// countQuasiIdentifiers reads the selected feedback once ahead of an export to count how often each combination of
+// quasi-identifiers occurs. Feedback added in between counts as a group of none and is exported suppressed.
+func countQuasiIdentifiers(repo repository.Interface, filter repository.FeedbackFilter, config *internal.Configuration) (*statistics.Aggregator, error) {
+	groups := statistics.NewAggregator(config.QuasiIdentifiers)
+	err := repo.ExportFeedbacks(filter, func(batch []repository.Feedback) error {
+		for _, feedback := range batch {
+			groups.Add(feedback.Rating, consentedMetadata(feedback, config))
+		}
+		return nil
+	})
+	return groups, err
+}
+
+// consentedMetadata strips the metadata of stored feedback again, as categories may have been added or consent may
+// have become required since it was stored.
+func consentedMetadata(feedback repository.Feedback, config *internal.Configuration) map[string]interface{} {
+	given := repository.MapToConsent(feedback.Consent)
+	return consent.Strip(feedback.Metadata, given, config.MetadataCategories, config.ConsentRequired)
+}
+
+func (c *Controller) deleteFeedbacks(writer http.ResponseWriter, request *http.Request) {
+	filter, err := parseFeedbackFilter(request)
+	if err == nil && filter.IsEmpty() {
+		err = errors.New("at least one of from, to, token_id, meeting_id, consent or no_consent is required")
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		logFor(request).Debug(err)
+		return
+	}
+
+	var deleted int64
+	err = c.repoFor(request).Transaction(func(repo repository.Interface) error {
+		deleted, err = repo.DeleteFeedbacks(filter)
+		if err != nil {
+			return err
+		}
+		return audit(repo, request, repository.AuditFeedbackDelete, request.URL.Query(), deleted)
+	})
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		logFor(request).Debug(err)
+		return
+	}
+	logFor(request).Infow("deleted feedbacks", "admin", auth.AdminFrom(request.Context()).Name, "deleted", deleted)
+
+	writer.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(writer).Encode(api.DeletionResponse{DeletedFeedbacks: deleted})
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// pseudonymize replaces the Matrix user ID of a revocation by its hash, so the audit log doesn't keep it.
+func pseudonymize(revocation api.Revocation) api.Revocation {
+	if revocation.MatrixUserId != "" {
+		revocation.Subject = auth.HashUserId(revocation.MatrixUserId)
+		revocation.MatrixUserId = ""
+	}
+	return revocation
+}
+
+// audit records an action of the admin of the request in the audit log.
+func audit(repo repository.Interface, request *http.Request, action string, parameters interface{}, affectedRows int64) error {
+	entry, err := repository.MapToAuditEntry(auth.AdminFrom(request.Context()).Name, action, parameters, affectedRows, tenant.IdFrom(request.Context()))
+	if err != nil {
+		return err
+	}
+	return repo.AppendAudit(entry)
+}
+
+func (c *Controller) getAuditEntries(writer http.ResponseWriter, request *http.Request) {
+	filter, err := parseAuditFilter(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		logFor(request).Debug(err)
+		return
+	}
+
+	entries, err := c.repoFor(request).FindAuditEntries(filter)
+	if err == nil {
+		err = audit(c.repoFor(request), request, repository.AuditAuditRead, request.URL.Query(), int64(len(entries)))
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		logFor(request).Debug(err)
+		return
+	}
+
+	response := make([]api.AuditEntry, 0, len(entries))
+	for _, entry := range entries {
+		response = append(response, repository.MapToApiAuditEntry(entry))
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(writer).Encode(response)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// parseAuditFilter reads the query parameters from and to (RFC 3339), actor, action, limit and offset.
+func parseAuditFilter(request *http.Request) (repository.AuditFilter, error) {
+	query := request.URL.Query()
+	filter := repository.AuditFilter{Tenant: tenant.IdFrom(request.Context()), Actor: query.Get("actor"), Action: query.Get("action")}
+	err := parseTimeRange(query, &filter.From, &filter.To)
+	if err == nil {
+		err = parsePaging(query, &filter.Limit, &filter.Offset)
+	}
+	return filter, err
+}
+
+// parseFeedbackFilter reads the query parameters from and to (RFC 3339), token_id, meeting_id, limit and offset.
+func parseFeedbackFilter(request *http.Request) (repository.FeedbackFilter, error) {
+	query := request.URL.Query()
+	filter := repository.FeedbackFilter{Tenant: tenant.IdFrom(request.Context()), TokenId: query.Get("token_id")}
+
+	err := parseTimeRange(query, &filter.From, &filter.To)
+	if err != nil {
+		return filter, err
+	}
+
+	if meetingId := query.Get(auth.MeetingIdParameter); meetingId != "" {
+		filter.Metadata = map[string]string{MeetingIdMetadataKey: meetingId}
+	}
+	filter.Consent = query.Get("consent")
+	filter.NoConsent = query.Get("no_consent")
+
+	err = parsePaging(query, &filter.Limit, &filter.Offset)
+	return filter, err
+}
+
+func parseTimeRange(query url.Values, from **time.Time, to **time.Time) error {
+	for name, target := range map[string]**time.Time{"from": from, "to": to} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return fmt.Errorf("%s is not an RFC 3339 timestamp: %s", name, value)
+			}
+			*target = &parsed
+		}
+	}
+	return nil
+}
+
+// parsePaging reads limit and offset. The limit defaults to DefaultPageSize and is capped at MaxPageSize.
+func parsePaging(query url.Values, limit *int, offset *int) error {
+	*limit = DefaultPageSize
+	for name, target := range map[string]*int{"limit": limit, "offset": offset} {
+		if value := query.Get(name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 0 {
+				return fmt.Errorf("%s is not a non-negative integer: %s", name, value)
+			}
+			*target = parsed
+		}
+	}
+	if *limit == 0 || *limit > MaxPageSize {
+		*limit = MaxPageSize
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package controller
+
+import (
+	"encoding/json"
+	"feedback/internal/api"
+	"feedback/internal/auth"
+	"feedback/internal/repository"
+	"net/http"
+	"time"
+)
+
+// UseAdminLogin enables the login of administrators through an identity provider.
+func (c *Controller) UseAdminLogin(login *auth.AdminLogin) {
+	c.adminLogin = login
+}
+
+func (c *Controller) startAdminLogin(writer http.ResponseWriter, request *http.Request) {
+	if c.adminLogin == nil {
+		http.NotFound(writer, request)
+		return
+	}
+	err := c.adminLogin.Start(writer, request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		logFor(request).Debug(err)
+		return
+	}
+}
+
+func (c *Controller) finishAdminLogin(writer http.ResponseWriter, request *http.Request) {
+	if c.adminLogin == nil {
+		http.NotFound(writer, request)
+		return
+	}
+	admin, returnTo, err := c.adminLogin.Finish(writer, request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusForbidden)
+		logFor(request).Debug(err)
+		return
+	}
+	logFor(request).Infow("admin logged in", "admin", admin.Name)
+	err = audit(c.repoFor(request), request.WithContext(auth.WithAdmin(request.Context(), admin)), repository.AuditAdminLogin, admin.Scopes, 0)
+	if err != nil {
+		logFor(request).Errorw("recording login in audit log failed", "error", err)
+	}
+	http.Redirect(writer, request, returnTo, http.StatusFound)
+}
+
+func (c *Controller) getAdminSession(writer http.ResponseWriter, request *http.Request) {
+	if c.adminLogin == nil {
+		http.NotFound(writer, request)
+		return
+	}
+	admin, session, err := c.adminLogin.Authenticate(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusUnauthorized)
+		logFor(request).Debug(err)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	err = json.NewEncoder(writer).Encode(api.AdminSession{
+		Name:      admin.Name,
+		Scopes:    admin.Scopes,
+		CsrfToken: session.CsrfToken,
+		ExpiresAt: time.Unix(session.ExpiresAt, 0).UTC(),
+	})
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c *Controller) logoutAdmin(writer http.ResponseWriter, request *http.Request) {
+	if c.adminLogin == nil {
+		http.NotFound(writer, request)
+		return
+	}
+	_, _, err := c.adminLogin.Authenticate(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusUnauthorized)
+		logFor(request).Debug(err)
+		return
+	}
+	c.adminLogin.Logout(writer, request)
+	writer.WriteHeader(http.StatusNoContent)
+}
@@ -23,45 +23,299 @@ import (
 	"feedback/internal"
 	"feedback/internal/api"
 	"feedback/internal/auth"
+	"feedback/internal/consent"
+	"feedback/internal/cors"
+	"feedback/internal/encryption"
+	"feedback/internal/health"
+	"feedback/internal/liveconfig"
 	"feedback/internal/logger"
+	"feedback/internal/metrics"
+	"feedback/internal/ratelimit"
 	"feedback/internal/repository"
+	"feedback/internal/tenant"
+	"feedback/internal/tracing"
+	"fmt"
 	"github.com/gorilla/mux"
 	"io"
+	"math"
 	"net/http"
 )
 
 const (
 	TokenPath    = "/token"
 	FeedbackPath = "/feedback"
+	JwksPath     = "/.well-known/jwks.json"
+
+	AnonymousTokenPath     = "/token/anonymous"
+	AnonymousChallengePath = "/token/anonymous/challenge"
+
+	RevocationsPath    = "/admin/revocations"
+	AdminFeedbackPath  = "/admin/feedback"
+	FeedbackExportPath = "/admin/feedback/export"
+	AdminLoginPath     = "/admin/login"
+	AdminCallbackPath  = "/admin/callback"
+	AdminSessionPath   = "/admin/session"
+	AdminLogoutPath    = "/admin/logout"
+	AuditPath          = "/admin/audit"
+	StatisticsPath     = "/admin/statistics"
+	LogLevelPath       = "/admin/log-level"
+
+	LivenessPath  = "/healthz"
+	ReadinessPath = "/readyz"
+
+	// MeetingIdMetadataKey is the metadata the Jitsi plugin sends the meeting in.
+	MeetingIdMetadataKey = "meetingId"
 )
 
 var log = logger.Instance()
 
 type Controller struct {
-	repo repository.Interface
-	serv *auth.OidcAuthentication
+	repo               repository.Interface
+	config             *liveconfig.Holder
+	keys               *auth.KeyCache
+	limits             ratelimit.Store
+	adminLogin         *auth.AdminLogin
+	tenants            *tenant.Registry
+	health             *health.Registry
+	clientCertificates *auth.ClientCertificates
+}
+
+// New creates a controller that serves the configuration held by config. Signing keys are loaded again whenever the
+// configuration is reloaded.
+func New(repo repository.Interface, config *liveconfig.Holder) *Controller {
+	keys := auth.NewKeyCache()
+	config.OnReload(func(*internal.Configuration) {
+		keys.Clear()
+	})
+	return &Controller{repo, config, keys, ratelimit.NewMemoryStore(), nil, nil, nil, nil}
 }
 
-func New(repo repository.Interface, serv *auth.OidcAuthentication) *Controller {
-	return &Controller{repo, serv}
+// UseTenants serves the tenants of the registry in addition to the default tenant of the global configuration.
+func (c *Controller) UseTenants(registry *tenant.Registry) {
+	c.tenants = registry
+}
+
+// configuration returns the global configuration with the settings of the tenant the request was resolved to.
+func (c *Controller) configuration(request *http.Request) *internal.Configuration {
+	return tenant.From(request.Context()).Apply(c.config.Current())
+}
+
+// authentication returns the authentication for a configuration of the request, which checks tokens against the
+// denylist of the repository bound to the request.
+func (c *Controller) authentication(request *http.Request, config *internal.Configuration) (*auth.OidcAuthentication, error) {
+	keys, err := c.keys.Load(config)
+	if err != nil {
+		return nil, err
+	}
+	return auth.NewWithKeys(config, keys, c.repoFor(request)), nil
+}
+
+// repoFor returns the repository bound to the context of the request, so that its statements are traced as part of it.
+func (c *Controller) repoFor(request *http.Request) repository.Interface {
+	return c.repo.WithContext(request.Context())
+}
+
+// UseRateLimitStore replaces the in-memory rate limits, e.g. with ones shared between replicas.
+func (c *Controller) UseRateLimitStore(store ratelimit.Store) {
+	c.limits = store
 }
 
 func (c *Controller) GetRouter() http.Handler {
 	router := mux.NewRouter().StrictSlash(true)
 	router.HandleFunc(TokenPath, c.createToken).Methods(http.MethodGet)
-	router.HandleFunc(TokenPath, c.returnOptions).Methods(http.MethodOptions)
 	router.HandleFunc(FeedbackPath, c.createFeedback).Methods(http.MethodPost)
-	router.HandleFunc(FeedbackPath, c.returnOptions).Methods(http.MethodOptions)
-	return router
+	router.HandleFunc(AnonymousChallengePath, c.createChallenge).Methods(http.MethodGet)
+	router.HandleFunc(AnonymousTokenPath, c.createAnonymousToken).Methods(http.MethodPost)
+	router.HandleFunc(JwksPath, c.getJwks).Methods(http.MethodGet)
+	router.HandleFunc(RevocationsPath, c.requireScope(auth.ScopeDelete, c.createRevocation)).Methods(http.MethodPost)
+	router.HandleFunc(AdminFeedbackPath, c.requireScope(auth.ScopeRead, c.getFeedbacks)).Methods(http.MethodGet)
+	router.HandleFunc(AdminFeedbackPath, c.requireScope(auth.ScopeDelete, c.deleteFeedbacks)).Methods(http.MethodDelete)
+	router.HandleFunc(FeedbackExportPath, c.requireScope(auth.ScopeExport, c.exportFeedbacks)).Methods(http.MethodGet)
+	router.HandleFunc(AuditPath, c.requireScope(auth.ScopeRead, c.getAuditEntries)).Methods(http.MethodGet)
+	router.HandleFunc(StatisticsPath, c.requireScope(auth.ScopeRead, c.getStatistics)).Methods(http.MethodGet)
+	router.HandleFunc(LogLevelPath, c.requireScope(auth.ScopeManageLogging, c.getLogLevel)).Methods(http.MethodGet)
+	router.HandleFunc(LogLevelPath, c.requireScope(auth.ScopeManageLogging, c.setLogLevel)).Methods(http.MethodPut)
+	router.HandleFunc(AdminLoginPath, c.startAdminLogin).Methods(http.MethodGet)
+	router.HandleFunc(AdminCallbackPath, c.finishAdminLogin).Methods(http.MethodGet)
+	router.HandleFunc(AdminSessionPath, c.getAdminSession).Methods(http.MethodGet)
+	router.HandleFunc(AdminLogoutPath, c.logoutAdmin).Methods(http.MethodPost)
+	router.Use(tracing.Route, annotateLog, metrics.Instrument, c.limitByIp)
+	router.NotFoundHandler = metrics.Instrument(http.NotFoundHandler())
+	router.MethodNotAllowedHandler = metrics.Instrument(http.HandlerFunc(methodNotAllowed))
+
+	// probes bypass tracing, request logs, tenants, CORS and rate limits
+	probes := mux.NewRouter()
+	probes.HandleFunc(LivenessPath, c.getLiveness).Methods(http.MethodGet)
+	probes.HandleFunc(ReadinessPath, c.getReadiness).Methods(http.MethodGet)
+	probes.PathPrefix("/").Handler(tracing.Handler(logRequests(c.resolveTenant(c.applyCors(router)))))
+	return probes
+}
+
+func methodNotAllowed(writer http.ResponseWriter, request *http.Request) {
+	http.Error(writer, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
+
+// resolveTenant runs first, so CORS and routing already see the tenant and the path without its prefix.
+func (c *Controller) resolveTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if c.tenants != nil {
+			request = c.tenants.Resolve(request)
+		}
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// applyCors wraps the whole router, so preflight requests are answered for every route.
+func (c *Controller) applyCors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		cors.NewPolicy(c.configuration(request)).Handle(writer, request, next)
+	})
+}
+
+// limitByIp applies RATE_LIMIT_IP to every request.
+func (c *Controller) limitByIp(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		config := c.configuration(request)
+		if !c.allow(writer, request, "ip:"+ratelimit.ClientIp(request, config.TrustedProxies), config.RateLimitIp) {
+			return
+		}
+		next.ServeHTTP(writer, request)
+	})
 }
 
 func (c *Controller) createToken(writer http.ResponseWriter, request *http.Request) {
-	addAccessControlHeaders(writer)
-	jwt, err := auth.New(internal.ConfigurationFromEnv()).Validate(request)
+	config := c.configuration(request)
+	meetingId := request.URL.Query().Get(auth.MeetingIdParameter)
+	if meetingId != "" && !c.allow(writer, request, "meeting:"+meetingId, config.RateLimitMeeting) {
+		return
+	}
+
+	authentication, err := c.authentication(request, config)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		logFor(request).Debug(err)
+		return
+	}
+
+	// the user is only known once the OpenID token has been validated remotely, so until then the token stands in for it
+	openIdToken, err := authentication.ExtractTokenFrom(request)
+	if err == nil && !c.allow(writer, request, "openid-token:"+auth.HashApiKey(*openIdToken), config.RateLimitSubject) {
+		return
+	}
+
+	jwt, claims, err := authentication.Validate(request)
+	if err != nil {
+		if errors.Is(err, auth.ErrUserNotValid) {
+			metrics.TokenRejected(metrics.ReasonUserNotValid)
+		} else {
+			metrics.TokenRejected(metrics.ReasonVerificationError)
+		}
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !c.allow(writer, request, "subject:"+claims.Subject, config.RateLimitSubject) {
+		return
+	}
+
+	err = c.repoFor(request).RegisterToken(repository.MapToTokenModel(claims.Id, claims.Subject, claims.MeetingId, claims.IssuedAt, claims.ExpiresAt, false, claims.Tenant))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		logFor(request).Debug(err)
+		return
+	}
+	metrics.TokenIssued(false)
+	writer.Header().Set("Content-Type", "text/plain")
+	_, err = writer.Write([]byte(*jwt))
+
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c *Controller) createChallenge(writer http.ResponseWriter, request *http.Request) {
+	config := c.configuration(request)
+	if !config.AnonymousTokens {
+		http.NotFound(writer, request)
+		return
+	}
+
+	authentication, err := c.authentication(request, config)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		logFor(request).Debug(err)
+		return
+	}
+
+	challenge, err := authentication.NewChallenge()
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		logFor(request).Debug(err)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(writer).Encode(challenge)
+
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c *Controller) createAnonymousToken(writer http.ResponseWriter, request *http.Request) {
+	config := c.configuration(request)
+	if !config.AnonymousTokens {
+		http.NotFound(writer, request)
+		return
+	}
+
+	if !c.allow(writer, request, "anonymous-ip:"+ratelimit.ClientIp(request, config.TrustedProxies), config.AnonymousIpLimit) {
+		return
+	}
+
+	var tokenRequest api.AnonymousTokenRequest
+	body, err := io.ReadAll(request.Body)
+	if err == nil {
+		err = json.Unmarshal(body, &tokenRequest)
+	}
 	if err != nil {
 		http.Error(writer, err.Error(), http.StatusBadRequest)
+		logFor(request).Debug(err)
+		return
+	}
+
+	authentication, err := c.authentication(request, config)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		logFor(request).Debug(err)
 		return
 	}
+
+	jwt, claims, err := authentication.ValidateAnonymous(tokenRequest)
+	if err != nil {
+		metrics.TokenRejected(metrics.ReasonChallenge)
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		logFor(request).Debug(err)
+		return
+	}
+
+	if claims.MeetingId != "" && !c.allow(writer, request, "anonymous-meeting:"+claims.MeetingId, config.AnonymousMeetingLimit) {
+		return
+	}
+
+	err = c.repoFor(request).RegisterToken(repository.MapToTokenModel(claims.Id, claims.Subject, claims.MeetingId, claims.IssuedAt, claims.ExpiresAt, true, claims.Tenant))
+	if err != nil {
+		if _, findErr := c.repoFor(request).FindToken(claims.Tenant, claims.Id); findErr == nil {
+			http.Error(writer, "challenge has already been used", http.StatusConflict)
+			return
+		}
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		logFor(request).Debug(err)
+		return
+	}
+	metrics.TokenIssued(true)
+
 	writer.Header().Set("Content-Type", "text/plain")
 	_, err = writer.Write([]byte(*jwt))
 
@@ -71,40 +325,133 @@ func (c *Controller) createToken(writer http.ResponseWriter, request *http.Reque
 	}
 }
 
+// allow takes a request from the rate limit of key, or answers with 429 Too Many Requests if it is exhausted. Limits
+// are kept per tenant.
+func (c *Controller) allow(writer http.ResponseWriter, request *http.Request, key string, limit internal.RateLimit) bool {
+	if tenantId := tenant.IdFrom(request.Context()); tenantId != "" {
+		key = tenantId + "/" + key
+	}
+	allowed, retryAfter := c.limits.Allow(key, limit)
+	if !allowed {
+		writer.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(retryAfter.Seconds()))))
+		http.Error(writer, "too many requests", http.StatusTooManyRequests)
+		logFor(request).Debugw("rate limit exceeded", "key", key)
+	}
+	return allowed
+}
+
+func (c *Controller) getJwks(writer http.ResponseWriter, request *http.Request) {
+	authentication, err := c.authentication(request, c.configuration(request))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		logFor(request).Debug(err)
+		return
+	}
+
+	jwks := authentication.Jwks()
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "max-age=300")
+	err = json.NewEncoder(writer).Encode(jwks)
+
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 func (c *Controller) createFeedback(writer http.ResponseWriter, request *http.Request) {
-	addAccessControlHeaders(writer)
-	authentication := auth.New(internal.ConfigurationFromEnv())
+	config := c.configuration(request)
+	authentication, err := c.authentication(request, config)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		logFor(request).Debug(err)
+		return
+	}
 
-	tokenString, err, authorized := c.authenticate(authentication, request)
-	if err != nil || !authorized {
+	err, claims := c.authenticate(authentication, request)
+	if err != nil {
+		reason := rejectionReason(err)
+		if reason == "" {
+			reason = metrics.ReasonInvalid
+		}
+		metrics.TokenRejected(reason)
 		http.Error(writer, err.Error(), http.StatusUnauthorized)
-		log.Debug(err)
+		logFor(request).Debug(err)
+		return
+	}
+
+	if !c.allow(writer, request, "subject:"+subjectKey(claims), config.RateLimitSubject) {
+		return
+	}
+	if claims.MeetingId != "" && !c.allow(writer, request, "meeting:"+claims.MeetingId, config.RateLimitMeeting) {
 		return
 	}
 
 	feedback, err := c.parseFeedback(err, request)
 	if err != nil {
 		http.Error(writer, err.Error(), http.StatusBadRequest)
-		log.Debug(err)
+		logFor(request).Debug(err)
 		return
 	}
 
-	err = c.createOrUpdate(tokenString, feedback)
+	err = bindMeeting(claims, &feedback)
 	if err != nil {
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
-		log.Debug(err)
+		metrics.TokenRejected(metrics.ReasonOtherMeeting)
+		http.Error(writer, err.Error(), http.StatusForbidden)
+		logFor(request).Debug(err)
+		return
+	}
+	feedback.Metadata = consent.Strip(feedback.Metadata, feedback.Consent, config.MetadataCategories, config.ConsentRequired)
+
+	err = c.repoFor(request).Transaction(func(repo repository.Interface) error {
+		err := repo.UseToken(claims.Tenant, claims.Id, config.TokenPolicy == internal.TokenPolicyEditable)
+		if err != nil {
+			return err
+		}
+		return createOrUpdate(repo, claims, feedback, config.Survey)
+	})
+	if err != nil {
+		if reason := rejectionReason(err); reason != "" {
+			metrics.TokenRejected(reason)
+		}
+		http.Error(writer, err.Error(), statusForSubmissionError(err))
+		logFor(request).Debug(err)
 		return
 	}
+	metrics.FeedbackStored(feedback.Rating)
 }
 
-func (c *Controller) createOrUpdate(tokenString *string, feedback api.Feedback) error {
-	fromDatabase, err := c.repo.FindByToken(*tokenString)
+// rejectionReason tells why the JWT of a submission was rejected, or returns an empty string if it was not.
+func rejectionReason(err error) string {
+	switch {
+	case errors.Is(err, auth.ErrTokenExpired):
+		return metrics.ReasonExpired
+	case errors.Is(err, auth.ErrTokenRevoked), errors.Is(err, repository.ErrTokenRevoked):
+		return metrics.ReasonRevoked
+	case errors.Is(err, repository.ErrTokenUsed):
+		return metrics.ReasonUsed
+	case errors.Is(err, repository.ErrTokenNotFound):
+		return metrics.ReasonUnregistered
+	}
+	return ""
+}
+
+// subjectKey tells anonymous tokens apart, which all share the same subject.
+func subjectKey(claims *auth.FeedbackClaims) string {
+	if claims.Anonymous {
+		return claims.Subject + ":" + claims.Id
+	}
+	return claims.Subject
+}
+
+func createOrUpdate(repo repository.Interface, claims *auth.FeedbackClaims, feedback api.Feedback, survey string) error {
+	fromDatabase, err := repo.FindByTokenId(claims.Tenant, claims.Id)
 	if err == nil {
 
-		if fromDatabase.Jwt == *tokenString {
+		if fromDatabase.TokenId == claims.Id {
 			log.Debug("token found in database, updating values")
-			feedbackToUpdateModel := *repository.MapToFeedbackModel(feedback, *tokenString)
-			_, err := c.repo.Update(feedbackToUpdateModel)
+			feedbackToUpdateModel := *repository.MapToFeedbackModel(feedback, claims.Id, claims.Anonymous, claims.Tenant, survey)
+			_, err := repo.Update(feedbackToUpdateModel)
 			if err != nil {
 				return errors.New("update of values failed")
 			} else {
@@ -112,34 +459,59 @@ func (c *Controller) createOrUpdate(tokenString *string, feedback api.Feedback)
 			}
 		}
 	}
-	return c.repo.Store(repository.MapToFeedbackModel(feedback, *tokenString))
+	return repo.Store(repository.MapToFeedbackModel(feedback, claims.Id, claims.Anonymous, claims.Tenant, survey))
+}
+
+func statusForSubmissionError(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrTokenNotFound), errors.Is(err, repository.ErrTokenRevoked):
+		return http.StatusUnauthorized
+	case errors.Is(err, repository.ErrTokenUsed):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
 }
 
-func (c *Controller) authenticate(authentication *auth.OidcAuthentication, request *http.Request) (*string, error, bool) {
+func (c *Controller) authenticate(authentication *auth.OidcAuthentication, request *http.Request) (error, *auth.FeedbackClaims) {
 	tokenString, err := authentication.ExtractTokenFrom(request)
 	if err != nil {
-		return nil, err, false
+		return err, nil
+	}
+	claims, err := authentication.IsAuthorized(tokenString)
+	return err, claims
+}
+
+// bindMeeting rejects feedback for another meeting than the one the token was issued for. Feedback that doesn't name a
+// meeting is recorded for the meeting of the token.
+func bindMeeting(claims *auth.FeedbackClaims, feedback *api.Feedback) error {
+	if claims.MeetingId == "" {
+		return nil
 	}
-	authorized, err := authentication.IsAuthorized(tokenString)
-	return tokenString, err, authorized
+	meetingId, found := feedback.Metadata[MeetingIdMetadataKey]
+	if !found {
+		if feedback.Metadata == nil {
+			feedback.Metadata = map[string]interface{}{}
+		}
+		feedback.Metadata[MeetingIdMetadataKey] = claims.MeetingId
+	} else if meetingId != claims.MeetingId {
+		return errors.New("token was issued for another meeting")
+	}
+	return nil
 }
 
 func (c *Controller) parseFeedback(err error, request *http.Request) (api.Feedback, error) {
 	var feedback api.Feedback
 	body, err := io.ReadAll(request.Body)
 	err = json.Unmarshal(body, &feedback)
-	return feedback, err
-}
-
-func (c *Controller) returnOptions(writer http.ResponseWriter, request *http.Request) {
-	writer.Header().Set("Access-Control-Allow-Origin", "*")
-	writer.Header().Set("Access-Control-Allow-Headers", request.Header.Get("Access-Control-Request-Headers"))
-	writer.Header().Set("Access-Control-Allow-Methods", "GET,HEAD,PUT,PATCH,POST,DELETE")
-	writer.WriteHeader(http.StatusNoContent)
-	return
-}
-
-func addAccessControlHeaders(writer http.ResponseWriter) {
-	writer.Header().Set("Access-Control-Allow-Origin", "*")
-	writer.Header().Set("Access-Control-Allow-Headers", "*")
+	if err != nil {
+		return feedback, err
+	}
+	// the prefix marks encrypted values in the database, so it must not come from participants
+	for key, value := range feedback.Metadata {
+		if text, ok := value.(string); ok && encryption.IsEncrypted(text) {
+			return feedback, fmt.Errorf("metadata %s must not start with %q", key, encryption.Prefix)
+		}
+	}
+	return feedback, nil
 }
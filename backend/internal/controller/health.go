@@ -0,0 +1,59 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package controller
+
+import (
+	"encoding/json"
+	"feedback/internal/api"
+	"feedback/internal/health"
+	"net/http"
+)
+
+// UseHealth answers readiness probes with the checks of the registry.
+func (c *Controller) UseHealth(registry *health.Registry) {
+	c.health = registry
+}
+
+// getLiveness only tells that the process serves requests, so that it is not restarted while a dependency is down.
+func (c *Controller) getLiveness(writer http.ResponseWriter, request *http.Request) {
+	writeHealth(writer, api.Health{Status: health.StatusOk})
+}
+
+// getReadiness is answered with 503 Service Unavailable if a critical check fails.
+func (c *Controller) getReadiness(writer http.ResponseWriter, request *http.Request) {
+	result := api.Health{Status: health.StatusOk}
+	if c.health != nil {
+		result = c.health.Run(request.Context())
+	}
+	if result.Status != health.StatusOk {
+		logFor(request).Debugw("readiness check failed", "checks", result.Checks)
+	}
+	writeHealth(writer, result)
+}
+
+func writeHealth(writer http.ResponseWriter, result api.Health) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	if result.Status == health.StatusUnavailable {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	}
+	err := json.NewEncoder(writer).Encode(result)
+	if err != nil {
+		log.Debug(err)
+	}
+}
@@ -0,0 +1,84 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package controller
+
+import (
+	"encoding/json"
+	"feedback/internal/api"
+	"feedback/internal/auth"
+	"feedback/internal/logger"
+	"feedback/internal/repository"
+	"io"
+	"net/http"
+)
+
+func (c *Controller) getLogLevel(writer http.ResponseWriter, request *http.Request) {
+	if !isGlobalAdmin(writer, request) {
+		return
+	}
+	writeLogLevel(writer)
+}
+
+// setLogLevel changes the level of all loggers, e.g. to debug an issue, until the next restart.
+func (c *Controller) setLogLevel(writer http.ResponseWriter, request *http.Request) {
+	if !isGlobalAdmin(writer, request) {
+		return
+	}
+
+	var level api.LogLevel
+	body, err := io.ReadAll(request.Body)
+	if err == nil {
+		err = json.Unmarshal(body, &level)
+	}
+	if err == nil {
+		err = logger.SetLevel(level.Level)
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		logFor(request).Debug(err)
+		return
+	}
+
+	err = audit(c.repoFor(request), request, repository.AuditLogLevelChange, level, 0)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		logFor(request).Debug(err)
+		return
+	}
+	logFor(request).Infow("log level changed", "admin", auth.AdminFrom(request.Context()).Name, "level", logger.Level())
+	writeLogLevel(writer)
+}
+
+// isGlobalAdmin rejects admins restricted to a tenant, including the default tenant, as the log level is shared by all
+// tenants.
+func isGlobalAdmin(writer http.ResponseWriter, request *http.Request) bool {
+	if admin := auth.AdminFrom(request.Context()); !admin.Global {
+		http.Error(writer, "the log level is shared by all tenants", http.StatusForbidden)
+		logFor(request).Debugw("tenant admin may not access the log level", "admin", admin.Name, "tenant", admin.Tenant)
+		return false
+	}
+	return true
+}
+
+func writeLogLevel(writer http.ResponseWriter) {
+	writer.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(writer).Encode(api.LogLevel{Level: logger.Level()})
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
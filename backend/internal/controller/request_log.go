@@ -0,0 +1,113 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package controller
+
+import (
+	"context"
+	"feedback/internal/logger"
+	"feedback/internal/requestid"
+	"feedback/internal/tenant"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"time"
+)
+
+type requestLogContextKey struct{}
+
+// requestLog collects what is only known once the request has been routed, for its access log entry.
+type requestLog struct {
+	route  string
+	tenant string
+}
+
+// logRequests assigns each request an ID, or keeps the one sent in X-Request-ID, and returns it in the response. The
+// logger of the request context carries the ID, and once routed, the route and tenant. Every request is logged when it
+// has been answered.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requestId := request.Header.Get(requestid.Header)
+		if !requestid.Valid(requestId) {
+			requestId = requestid.New()
+		}
+		writer.Header().Set(requestid.Header, requestId)
+
+		fields := []interface{}{"request_id", requestId}
+		if span := trace.SpanContextFromContext(request.Context()); span.HasTraceID() {
+			fields = append(fields, "trace_id", span.TraceID().String())
+		}
+		requestLogger := log.With(fields...)
+		entry := &requestLog{route: "unknown"}
+		ctx := logger.WithContext(requestid.WithContext(request.Context(), requestId), requestLogger)
+		ctx = context.WithValue(ctx, requestLogContextKey{}, entry)
+
+		recorder := &responseRecorder{ResponseWriter: writer, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(recorder, request.WithContext(ctx))
+		requestLogger.Infow("request",
+			"method", request.Method,
+			"path", request.URL.Path,
+			"route", entry.route,
+			"tenant", entry.tenant,
+			"status", recorder.status,
+			"bytes", recorder.bytes,
+			"duration", time.Since(start))
+	})
+}
+
+// annotateLog adds the route and tenant to the logger of a request once mux has matched it.
+func annotateLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		entry, found := request.Context().Value(requestLogContextKey{}).(*requestLog)
+		if !found {
+			next.ServeHTTP(writer, request)
+			return
+		}
+		if current := mux.CurrentRoute(request); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				entry.route = template
+			}
+		}
+		entry.tenant = tenant.IdFrom(request.Context())
+
+		requestLogger := logger.From(request.Context()).With("route", entry.route, "tenant", entry.tenant)
+		next.ServeHTTP(writer, request.WithContext(logger.WithContext(request.Context(), requestLogger)))
+	})
+}
+
+// logFor returns the logger of a request, which carries its ID, route and tenant.
+func logFor(request *http.Request) logger.Logger {
+	return logger.From(request.Context())
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (recorder *responseRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *responseRecorder) Write(content []byte) (int, error) {
+	written, err := recorder.ResponseWriter.Write(content)
+	recorder.bytes += written
+	return written, err
+}
@@ -0,0 +1,142 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"feedback/internal"
+	"feedback/internal/api"
+	"feedback/internal/auth"
+	"feedback/internal/repository"
+	"feedback/internal/statistics"
+	"feedback/internal/tenant"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// getStatistics aggregates the feedback selected like for exports by the metadata keys of group_by, e.g.
+// group_by=appShard,userRegion. small_groups tells whether buckets below MIN_GROUP_SIZE are merged (default) or
+// suppressed. With noise=laplace or noise=gaussian, the buckets are differentially private and the query spends
+// epsilon, PRIVACY_EPSILON by default, from the privacy budget of the tenant. Statistics without noise require the
+// scope exact-statistics.
+func (c *Controller) getStatistics(writer http.ResponseWriter, request *http.Request) {
+	config := c.configuration(request)
+	query := request.URL.Query()
+
+	filter, err := parseFeedbackFilter(request)
+	smallGroups := query.Get("small_groups")
+	if smallGroups == "" {
+		smallGroups = statistics.SmallGroupsMerge
+	}
+	if err == nil && smallGroups != statistics.SmallGroupsMerge && smallGroups != statistics.SmallGroupsSuppress {
+		err = fmt.Errorf("small_groups is neither %s nor %s: %s", statistics.SmallGroupsMerge, statistics.SmallGroupsSuppress, smallGroups)
+	}
+	var mechanism *statistics.Mechanism
+	if err == nil && query.Get("noise") != "" {
+		mechanism, err = parseMechanism(query, config)
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		logFor(request).Debug(err)
+		return
+	}
+	if admin := auth.AdminFrom(request.Context()); mechanism == nil && !admin.HasScope(auth.ScopeExactStatistics) {
+		http.Error(writer, "noise is required without scope "+auth.ScopeExactStatistics, http.StatusForbidden)
+		logFor(request).Debugw("admin lacks scope", "admin", admin.Name, "scope", auth.ScopeExactStatistics)
+		return
+	}
+
+	var privacy *api.StatisticsPrivacy
+	if mechanism != nil {
+		tenantId := tenant.IdFrom(request.Context())
+		remaining, resetAt, err := c.repoFor(request).SpendPrivacyBudget(tenantId, mechanism.Epsilon, config.PrivacyBudget, config.PrivacyBudgetWindow)
+		if errors.Is(err, repository.ErrPrivacyBudgetExhausted) {
+			writer.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(time.Until(resetAt).Seconds()))))
+			http.Error(writer, fmt.Sprintf("privacy budget exhausted, %g of %g left", remaining, config.PrivacyBudget), http.StatusTooManyRequests)
+			logFor(request).Debugw("privacy budget exhausted", "tenant", tenantId)
+			return
+		}
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			logFor(request).Debug(err)
+			return
+		}
+		privacy = &api.StatisticsPrivacy{
+			Noise:           mechanism.Noise,
+			Epsilon:         mechanism.Epsilon,
+			Delta:           mechanism.Delta,
+			RemainingBudget: remaining,
+			BudgetResetAt:   resetAt,
+		}
+	}
+
+	var groupBy []string
+	for _, key := range strings.Split(query.Get("group_by"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			groupBy = append(groupBy, key)
+		}
+	}
+
+	aggregator := statistics.NewAggregator(groupBy)
+	if mechanism != nil {
+		aggregator.AddNoise(mechanism)
+	}
+	err = c.repoFor(request).ExportFeedbacks(filter, func(batch []repository.Feedback) error {
+		for _, feedback := range batch {
+			aggregator.Add(feedback.Rating, consentedMetadata(feedback, config))
+		}
+		return nil
+	})
+	buckets := aggregator.Buckets(config.MinGroupSize, smallGroups)
+	if err == nil {
+		err = audit(c.repoFor(request), request, repository.AuditStatisticsRead, query, int64(len(buckets)))
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		logFor(request).Debug(err)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(writer).Encode(api.Statistics{MinGroupSize: config.MinGroupSize, Buckets: buckets, Privacy: privacy})
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func parseMechanism(query url.Values, config *internal.Configuration) (*statistics.Mechanism, error) {
+	epsilon := config.PrivacyEpsilon
+	if value := query.Get("epsilon"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("epsilon is not a number: %s", value)
+		}
+		epsilon = parsed
+	}
+	if epsilon > config.PrivacyBudget {
+		return nil, fmt.Errorf("epsilon %g exceeds the privacy budget of %g", epsilon, config.PrivacyBudget)
+	}
+	return statistics.NewMechanism(query.Get("noise"), epsilon, config.PrivacyDelta)
+}
@@ -0,0 +1,114 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package cors
+
+import (
+	"feedback/internal"
+	"feedback/internal/requestid"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy decides which cross-origin requests browsers may send to the API.
+type Policy struct {
+	// AllowedOrigins contains origins such as "https://meet.example.com", "https://*.example.com" for any subdomain,
+	// or "*" for any origin. "*" never allows requests with credentials, as any site could read responses meant for
+	// the user, e.g. the CSRF token of an admin session.
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	MaxAge           time.Duration
+	AllowCredentials bool
+}
+
+// ExposedHeaders can be read by scripts of allowed origins in addition to the CORS-safelisted ones.
+var ExposedHeaders = []string{"Retry-After", requestid.Header}
+
+func NewPolicy(config *internal.Configuration) *Policy {
+	return &Policy{
+		AllowedOrigins:   config.CorsAllowedOrigins,
+		AllowedMethods:   config.CorsAllowedMethods,
+		AllowedHeaders:   config.CorsAllowedHeaders,
+		MaxAge:           config.CorsMaxAge,
+		AllowCredentials: config.CorsAllowCredentials,
+	}
+}
+
+// Handle adds the CORS headers for allowed origins and answers preflight requests itself, so they don't reach next.
+func (policy *Policy) Handle(writer http.ResponseWriter, request *http.Request, next http.Handler) {
+	writer.Header().Add("Vary", "Origin")
+	origin := request.Header.Get("Origin")
+	anyOrigin := policy.allowsAnyOrigin() && !policy.AllowCredentials
+	allowed := anyOrigin || origin != "" && policy.IsAllowed(origin)
+
+	if allowed {
+		if anyOrigin {
+			writer.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			writer.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if policy.AllowCredentials {
+			writer.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+
+	if request.Method != http.MethodOptions {
+		if allowed {
+			writer.Header().Set("Access-Control-Expose-Headers", strings.Join(ExposedHeaders, ","))
+		}
+		next.ServeHTTP(writer, request)
+		return
+	}
+
+	if allowed {
+		writer.Header().Add("Vary", "Access-Control-Request-Method")
+		writer.Header().Add("Vary", "Access-Control-Request-Headers")
+		writer.Header().Set("Access-Control-Allow-Methods", strings.Join(policy.AllowedMethods, ","))
+		writer.Header().Set("Access-Control-Allow-Headers", strings.Join(policy.AllowedHeaders, ","))
+		if policy.MaxAge > 0 {
+			writer.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge.Seconds())))
+		}
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (policy *Policy) IsAllowed(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range policy.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+		if allowed == "*" && !policy.AllowCredentials || allowed == origin {
+			return true
+		}
+		scheme, domain, found := strings.Cut(allowed, "://*.")
+		if found && strings.HasPrefix(origin, scheme+"://") && strings.HasSuffix(origin, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func (policy *Policy) allowsAnyOrigin() bool {
+	for _, allowed := range policy.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
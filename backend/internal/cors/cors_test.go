@@ -0,0 +1,78 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package cors
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPolicy_IsAllowed(t *testing.T) {
+	policy := &Policy{AllowedOrigins: []string{"https://meet.domain.tld", "https://*.jitsi.tld"}}
+
+	assert.True(t, policy.IsAllowed("https://meet.domain.tld"))
+	assert.True(t, policy.IsAllowed("https://a.jitsi.tld"))
+	assert.True(t, policy.IsAllowed("https://a.b.jitsi.tld"))
+	assert.False(t, policy.IsAllowed("https://jitsi.tld"))
+	assert.False(t, policy.IsAllowed("http://a.jitsi.tld"))
+	assert.False(t, policy.IsAllowed("https://evil-jitsi.tld"))
+	assert.False(t, policy.IsAllowed("https://meet.domain.tld.evil.tld"))
+}
+
+func TestPolicy_Preflight(t *testing.T) {
+	policy := &Policy{
+		AllowedOrigins:   []string{"https://meet.domain.tld"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"Authorization"},
+		MaxAge:           10 * time.Minute,
+		AllowCredentials: true,
+	}
+	request := httptest.NewRequest(http.MethodOptions, "/feedback", nil)
+	request.Header.Set("Origin", "https://meet.domain.tld")
+	responseWriter := httptest.NewRecorder()
+
+	policy.Handle(responseWriter, request, http.NotFoundHandler())
+
+	assert.Equal(t, 204, responseWriter.Result().StatusCode)
+	assert.Equal(t, "https://meet.domain.tld", responseWriter.Result().Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", responseWriter.Result().Header.Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "GET,POST", responseWriter.Result().Header.Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Authorization", responseWriter.Result().Header.Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", responseWriter.Result().Header.Get("Access-Control-Max-Age"))
+}
+
+func TestPolicy_AnyOriginWithCredentials(t *testing.T) {
+	policy := &Policy{
+		AllowedOrigins:   []string{"*"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"Authorization"},
+		AllowCredentials: true,
+	}
+	request := httptest.NewRequest(http.MethodOptions, "/admin/session", nil)
+	request.Header.Set("Origin", "https://evil.tld")
+	responseWriter := httptest.NewRecorder()
+
+	policy.Handle(responseWriter, request, http.NotFoundHandler())
+
+	assert.False(t, policy.IsAllowed("https://evil.tld"))
+	assert.Equal(t, "", responseWriter.Result().Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "", responseWriter.Result().Header.Get("Access-Control-Allow-Credentials"))
+}
@@ -0,0 +1,210 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"feedback/internal"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	// Prefix marks encrypted field values, so plaintext written before encryption was enabled can still be read.
+	Prefix = "enc:"
+	// InlinePrefix marks a key given directly in ENCRYPTION_KEYS instead of a path to a key file.
+	InlinePrefix = "base64:"
+
+	keySize = 32
+)
+
+// Keyring holds the key-encryption keys by version. The active key wraps new data keys, the others are only used to
+// unwrap data keys of rows that haven't been re-encrypted yet.
+type Keyring struct {
+	active string
+	keys   map[string][]byte
+}
+
+// DataKey encrypts the fields of one row. It is stored wrapped by a key-encryption key next to the fields.
+type DataKey struct {
+	Version string
+	Wrapped string
+	key     []byte
+}
+
+// LoadKeyring reads the keys of ENCRYPTION_KEYS, which are base64 encoded 256 bit keys. It returns nil if encryption
+// is not configured.
+func LoadKeyring(config *internal.Configuration) (*Keyring, error) {
+	if strings.TrimSpace(config.EncryptionKeys) == "" {
+		return nil, nil
+	}
+
+	keyring := &Keyring{keys: map[string][]byte{}}
+	for _, entry := range strings.Split(config.EncryptionKeys, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		versionAndKey := strings.SplitN(entry, "=", 2)
+		if len(versionAndKey) != 2 || strings.TrimSpace(versionAndKey[0]) == "" {
+			return nil, errors.New("encryption key entry is not of the form version=path")
+		}
+		version := strings.TrimSpace(versionAndKey[0])
+		if _, found := keyring.keys[version]; found {
+			return nil, fmt.Errorf("encryption key version %s is configured twice", version)
+		}
+		key, err := readKey(version, strings.TrimSpace(versionAndKey[1]))
+		if err != nil {
+			return nil, err
+		}
+		keyring.keys[version] = key
+		if keyring.active == "" {
+			keyring.active = version
+		}
+	}
+
+	if config.EncryptionActiveKey != "" {
+		keyring.active = config.EncryptionActiveKey
+	}
+	if _, found := keyring.keys[keyring.active]; !found {
+		return nil, fmt.Errorf("active encryption key %s is not configured", keyring.active)
+	}
+	return keyring, nil
+}
+
+func readKey(version string, source string) ([]byte, error) {
+	encoded := strings.TrimPrefix(source, InlinePrefix)
+	if encoded == source {
+		content, err := os.ReadFile(source)
+		if err != nil {
+			return nil, err
+		}
+		encoded = strings.TrimSpace(string(content))
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != keySize {
+		return nil, fmt.Errorf("encryption key %s is not a base64 encoded %d byte key", version, keySize)
+	}
+	return key, nil
+}
+
+// ActiveVersion is the version of the key that wraps new data keys.
+func (keyring *Keyring) ActiveVersion() string {
+	return keyring.active
+}
+
+// NewDataKey generates a data key wrapped by the active key.
+func (keyring *Keyring) NewDataKey() (*DataKey, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	wrapped, err := seal(keyring.keys[keyring.active], key, keyring.active)
+	if err != nil {
+		return nil, err
+	}
+	return &DataKey{Version: keyring.active, Wrapped: wrapped, key: key}, nil
+}
+
+// Unwrap decrypts a stored data key with the key of its version.
+func (keyring *Keyring) Unwrap(version string, wrapped string) (*DataKey, error) {
+	kek, found := keyring.keys[version]
+	if !found {
+		return nil, fmt.Errorf("encryption key %s is not configured", version)
+	}
+	key, err := open(kek, wrapped, version)
+	if err != nil {
+		return nil, fmt.Errorf("data key can't be unwrapped with encryption key %s: %w", version, err)
+	}
+	return &DataKey{Version: version, Wrapped: wrapped, key: key}, nil
+}
+
+// Rewrap wraps a data key with the active key, which rotates the key-encryption key without touching the fields.
+func (keyring *Keyring) Rewrap(dataKey *DataKey) (*DataKey, error) {
+	wrapped, err := seal(keyring.keys[keyring.active], dataKey.key, keyring.active)
+	if err != nil {
+		return nil, err
+	}
+	return &DataKey{Version: keyring.active, Wrapped: wrapped, key: dataKey.key}, nil
+}
+
+// Encrypt encrypts the value of a field. The field name is authenticated, so values can't be moved between fields.
+func (dataKey *DataKey) Encrypt(field string, value string) (string, error) {
+	sealed, err := seal(dataKey.key, []byte(value), field)
+	if err != nil {
+		return "", err
+	}
+	return Prefix + sealed, nil
+}
+
+// Decrypt decrypts the value of a field. Values without Prefix are returned as they are.
+func (dataKey *DataKey) Decrypt(field string, value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	plaintext, err := open(dataKey.key, strings.TrimPrefix(value, Prefix), field)
+	if err != nil {
+		return "", fmt.Errorf("field %s can't be decrypted: %w", field, err)
+	}
+	return string(plaintext), nil
+}
+
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, Prefix)
+}
+
+// seal encrypts with AES-256-GCM and returns the base64 encoded nonce and ciphertext.
+func seal(key []byte, plaintext []byte, additionalData string) (string, error) {
+	aead, err := newAead(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, []byte(additionalData))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func open(key []byte, encoded string, additionalData string) ([]byte, error) {
+	aead, err := newAead(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(additionalData))
+}
+
+func newAead(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,107 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package encryption
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"feedback/internal"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newKey(t *testing.T) string {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func TestDataKey_EncryptAndDecrypt(t *testing.T) {
+	keyring, err := LoadKeyring(&internal.Configuration{EncryptionKeys: "v1=" + InlinePrefix + newKey(t)})
+	assert.Nil(t, err)
+	dataKey, err := keyring.NewDataKey()
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", dataKey.Version)
+
+	encrypted, err := dataKey.Encrypt("rating_comment", "great, thanks")
+	assert.Nil(t, err)
+	assert.True(t, IsEncrypted(encrypted))
+	assert.NotContains(t, encrypted, "great")
+
+	unwrapped, err := keyring.Unwrap(dataKey.Version, dataKey.Wrapped)
+	assert.Nil(t, err)
+	decrypted, err := unwrapped.Decrypt("rating_comment", encrypted)
+	assert.Nil(t, err)
+	assert.Equal(t, "great, thanks", decrypted)
+
+	_, err = unwrapped.Decrypt("metadata.displayName", encrypted)
+	assert.NotNil(t, err, "values are bound to their field")
+
+	plaintext, err := unwrapped.Decrypt("rating_comment", "written before encryption")
+	assert.Nil(t, err)
+	assert.Equal(t, "written before encryption", plaintext)
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	oldKey := newKey(t)
+	newPath := filepath.Join(t.TempDir(), "v2.key")
+	assert.Nil(t, os.WriteFile(newPath, []byte(newKey(t)+"\n"), 0600))
+
+	oldKeyring, err := LoadKeyring(&internal.Configuration{EncryptionKeys: "v1=" + InlinePrefix + oldKey})
+	assert.Nil(t, err)
+	dataKey, _ := oldKeyring.NewDataKey()
+	encrypted, _ := dataKey.Encrypt("rating_comment", "great, thanks")
+
+	keyring, err := LoadKeyring(&internal.Configuration{
+		EncryptionKeys:      "v1=" + InlinePrefix + oldKey + ",v2=" + newPath,
+		EncryptionActiveKey: "v2",
+	})
+	assert.Nil(t, err)
+	unwrapped, err := keyring.Unwrap(dataKey.Version, dataKey.Wrapped)
+	assert.Nil(t, err)
+	rewrapped, err := keyring.Rewrap(unwrapped)
+	assert.Nil(t, err)
+	assert.Equal(t, "v2", rewrapped.Version)
+
+	rotatedKeyring, err := LoadKeyring(&internal.Configuration{EncryptionKeys: "v2=" + newPath})
+	assert.Nil(t, err)
+	_, err = rotatedKeyring.Unwrap(dataKey.Version, dataKey.Wrapped)
+	assert.NotNil(t, err)
+	unwrapped, err = rotatedKeyring.Unwrap(rewrapped.Version, rewrapped.Wrapped)
+	assert.Nil(t, err)
+	decrypted, err := unwrapped.Decrypt("rating_comment", encrypted)
+	assert.Nil(t, err)
+	assert.Equal(t, "great, thanks", decrypted)
+}
+
+func TestLoadKeyring_Invalid(t *testing.T) {
+	keyring, err := LoadKeyring(&internal.Configuration{})
+	assert.Nil(t, err)
+	assert.Nil(t, keyring)
+
+	for _, keys := range []string{"v1=" + InlinePrefix + "c2hvcnQ=", "v1", "v1=/does/not/exist"} {
+		_, err = LoadKeyring(&internal.Configuration{EncryptionKeys: keys})
+		assert.NotNil(t, err, keys)
+	}
+	_, err = LoadKeyring(&internal.Configuration{EncryptionKeys: "v1=" + InlinePrefix + newKey(t), EncryptionActiveKey: "v2"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,125 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package health
+
+import (
+	"context"
+	"errors"
+	"feedback/internal/api"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status of a single check and of all checks together
+const (
+	StatusUp          = "up"
+	StatusDown        = "down"
+	StatusOk          = "ok"
+	StatusDegraded    = "degraded"
+	StatusUnavailable = "unavailable"
+)
+
+// Check tells whether a dependency is usable. It should give up once ctx is done.
+type Check func(ctx context.Context) error
+
+// Registry holds the checks that subsystems register to tell whether the backend is ready to serve requests.
+type Registry struct {
+	mutex  sync.RWMutex
+	checks []registeredCheck
+}
+
+type registeredCheck struct {
+	name     string
+	timeout  time.Duration
+	critical bool
+	check    Check
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a check that fails if it takes longer than timeout. A failing critical check makes the backend
+// unavailable, other failing checks only degrade it.
+func (registry *Registry) Register(name string, timeout time.Duration, critical bool, check Check) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.checks = append(registry.checks, registeredCheck{name, timeout, critical, check})
+}
+
+// Run runs all checks concurrently and waits for each until its timeout.
+func (registry *Registry) Run(ctx context.Context) api.Health {
+	registry.mutex.RLock()
+	checks := append([]registeredCheck(nil), registry.checks...)
+	registry.mutex.RUnlock()
+	sort.Slice(checks, func(i, j int) bool {
+		return checks[i].name < checks[j].name
+	})
+
+	results := make([]api.HealthCheck, len(checks))
+	var wait sync.WaitGroup
+	for i := range checks {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			results[i] = checks[i].run(ctx)
+		}(i)
+	}
+	wait.Wait()
+
+	health := api.Health{Status: StatusOk, Checks: map[string]api.HealthCheck{}}
+	for i, result := range results {
+		health.Checks[checks[i].name] = result
+		if result.Status == StatusUp {
+			continue
+		}
+		if checks[i].critical {
+			health.Status = StatusUnavailable
+		} else if health.Status == StatusOk {
+			health.Status = StatusDegraded
+		}
+	}
+	return health
+}
+
+// run waits for the check until the timeout, even if the check itself ignores ctx.
+func (registered registeredCheck) run(ctx context.Context) api.HealthCheck {
+	ctx, cancel := context.WithTimeout(ctx, registered.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- registered.check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = errors.New("timed out after " + registered.timeout.String())
+	}
+
+	result := api.HealthCheck{Status: StatusUp, Critical: registered.critical, DurationMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,51 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package health
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRegistry_Run(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("database", time.Second, true, func(ctx context.Context) error {
+		return nil
+	})
+	assert.Equal(t, StatusOk, registry.Run(context.Background()).Status)
+
+	registry.Register("uvs", time.Second, false, func(ctx context.Context) error {
+		return errors.New("connection refused")
+	})
+	result := registry.Run(context.Background())
+	assert.Equal(t, StatusDegraded, result.Status)
+	assert.Equal(t, StatusUp, result.Checks["database"].Status)
+	assert.Equal(t, "connection refused", result.Checks["uvs"].Error)
+
+	registry.Register("migrations", 10*time.Millisecond, true, func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+	result = registry.Run(context.Background())
+	assert.Equal(t, StatusUnavailable, result.Status)
+	assert.Equal(t, "timed out after 10ms", result.Checks["migrations"].Error)
+	assert.Less(t, result.Checks["migrations"].DurationMs, int64(500))
+}
@@ -0,0 +1,143 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package liveconfig
+
+import (
+	"context"
+	"feedback/internal"
+	"feedback/internal/logger"
+	"feedback/internal/metrics"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// CheckInterval is how often the configuration file is checked for changes.
+const CheckInterval = 10 * time.Second
+
+var log = logger.Instance()
+
+// Holder keeps the active configuration. A reloaded configuration replaces it atomically, and only once it has passed
+// all checks, so that requests never see an invalid or partly applied one.
+type Holder struct {
+	current   atomic.Pointer[internal.Configuration]
+	load      func() (*internal.Configuration, error)
+	mutex     sync.Mutex
+	checks    []func(*internal.Configuration) error
+	listeners []func(*internal.Configuration)
+}
+
+// New holds config and reloads it with load, e.g. internal.LoadConfiguration.
+func New(config *internal.Configuration, load func() (*internal.Configuration, error)) *Holder {
+	holder := &Holder{load: load}
+	holder.current.Store(config)
+	return holder
+}
+
+func (holder *Holder) Current() *internal.Configuration {
+	return holder.current.Load()
+}
+
+// UseCheck adds a check a reloaded configuration has to pass before it is activated, beyond the validation of its
+// settings, e.g. that its key files can be read.
+func (holder *Holder) UseCheck(check func(*internal.Configuration) error) {
+	holder.mutex.Lock()
+	defer holder.mutex.Unlock()
+	holder.checks = append(holder.checks, check)
+}
+
+// OnReload adds a listener that is called with every configuration that has been activated.
+func (holder *Holder) OnReload(listener func(*internal.Configuration)) {
+	holder.mutex.Lock()
+	defer holder.mutex.Unlock()
+	holder.listeners = append(holder.listeners, listener)
+}
+
+// Reload loads the configuration again and activates it if it is valid. Otherwise, the active configuration is kept.
+func (holder *Holder) Reload() error {
+	holder.mutex.Lock()
+	defer holder.mutex.Unlock()
+
+	config, err := holder.load()
+	for i := 0; err == nil && i < len(holder.checks); i++ {
+		err = holder.checks[i](config)
+	}
+	if err != nil {
+		metrics.ConfigReloaded(false)
+		log.Errorw("kept the active configuration, the reloaded one is invalid", "error", err)
+		return err
+	}
+
+	changed, restart := holder.Current().Changes(config)
+	holder.current.Store(config)
+	for _, listener := range holder.listeners {
+		listener(config)
+	}
+	metrics.ConfigReloaded(true)
+	log.Infow("reloaded configuration", "changed", changed)
+	if len(restart) > 0 {
+		log.Warnw("changed settings take effect after a restart", "settings", restart)
+	}
+	return nil
+}
+
+// Watch reloads the configuration on SIGHUP and whenever the file at path, if any, has been modified, until ctx is done.
+// SIGHUP is handled as soon as Watch returns, so that it no longer terminates the process.
+func (holder *Holder) Watch(ctx context.Context, path string) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go holder.watch(ctx, path, hangups)
+}
+
+func (holder *Holder) watch(ctx context.Context, path string, hangups chan os.Signal) {
+	defer signal.Stop(hangups)
+
+	var checks <-chan time.Time
+	modified := lastModified(path)
+	if path != "" {
+		ticker := time.NewTicker(CheckInterval)
+		defer ticker.Stop()
+		checks = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangups:
+			_ = holder.Reload()
+		case <-checks:
+			if current := lastModified(path); !current.Equal(modified) {
+				modified = current
+				_ = holder.Reload()
+			}
+		}
+	}
+}
+
+// lastModified returns the modification time of a file, or the zero time if it cannot be read.
+func lastModified(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
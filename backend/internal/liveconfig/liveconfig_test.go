@@ -0,0 +1,82 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package liveconfig
+
+import (
+	"context"
+	"errors"
+	"feedback/internal"
+	"github.com/stretchr/testify/assert"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestHolder_Reload(t *testing.T) {
+	active := &internal.Configuration{Survey: "before"}
+	var loaded *internal.Configuration
+	var loadErr error
+	holder := New(active, func() (*internal.Configuration, error) {
+		return loaded, loadErr
+	})
+	var checkErr error
+	holder.UseCheck(func(*internal.Configuration) error {
+		return checkErr
+	})
+	var notified *internal.Configuration
+	holder.OnReload(func(config *internal.Configuration) {
+		notified = config
+	})
+
+	loadErr = errors.New("invalid configuration")
+	assert.Equal(t, loadErr, holder.Reload())
+	assert.Same(t, active, holder.Current())
+
+	loaded, loadErr = &internal.Configuration{Survey: "after"}, nil
+	checkErr = errors.New("signing key is not readable")
+	assert.Equal(t, checkErr, holder.Reload())
+	assert.Same(t, active, holder.Current())
+	assert.Nil(t, notified)
+
+	checkErr = nil
+	assert.Nil(t, holder.Reload())
+	assert.Same(t, loaded, holder.Current())
+	assert.Same(t, loaded, notified)
+}
+
+func TestHolder_Watch_HandlesHangupOnceReturned(t *testing.T) {
+	reloaded := make(chan struct{}, 1)
+	holder := New(&internal.Configuration{}, func() (*internal.Configuration, error) {
+		return &internal.Configuration{}, nil
+	})
+	holder.OnReload(func(*internal.Configuration) {
+		reloaded <- struct{}{}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	holder.Watch(ctx, "")
+	// without a handler, SIGHUP would terminate the test binary
+	assert.Nil(t, syscall.Kill(syscall.Getpid(), syscall.SIGHUP))
+
+	select {
+	case <-reloaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("configuration was not reloaded")
+	}
+}
@@ -33,7 +33,7 @@ func GooseLoggerWrapper(logger Logger) goose.Logger {
 }
 
 func (gl *GooseLogger) Fatal(v ...interface{}) {
-	gl.logger.Error(v)
+	gl.logger.Error(v...)
 }
 
 func (gl *GooseLogger) Fatalf(format string, v ...interface{}) {
@@ -41,11 +41,11 @@ func (gl *GooseLogger) Fatalf(format string, v ...interface{}) {
 }
 
 func (gl *GooseLogger) Print(v ...interface{}) {
-	gl.logger.Info(v)
+	gl.logger.Info(v...)
 }
 
 func (gl *GooseLogger) Println(v ...interface{}) {
-	gl.logger.Info(v)
+	gl.logger.Info(v...)
 }
 
 func (gl *GooseLogger) Printf(format string, v ...interface{}) {
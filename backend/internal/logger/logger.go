@@ -18,6 +18,9 @@
 package logger
 
 import (
+	"context"
+	"feedback/internal"
+	"fmt"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"os"
@@ -27,7 +30,9 @@ import (
 
 var (
 	once     sync.Once
-	instance Logger
+	instance *AppLogger
+	// level is shared by all loggers, so that it can be changed at runtime.
+	level = zap.NewAtomicLevelAt(zap.InfoLevel)
 )
 
 type Logger interface {
@@ -38,6 +43,13 @@ type Logger interface {
 	Fatal(...interface{})
 	DPanic(...interface{})
 	Panic(...interface{})
+	// Debugw, Infow, Warnw and Errorw log a message with fields given as alternating keys and values.
+	Debugw(string, ...interface{})
+	Infow(string, ...interface{})
+	Warnw(string, ...interface{})
+	Errorw(string, ...interface{})
+	// With returns a logger that adds the fields given as alternating keys and values to every entry.
+	With(...interface{}) Logger
 	OnExit()
 }
 
@@ -45,30 +57,91 @@ type AppLogger struct {
 	logger *zap.SugaredLogger
 }
 
+// Instance returns the logger shared by all packages. Until Configure is called, it logs on the console from level info
+// and redacts the default LOG_REDACTED_KEYS, as loggers are created before the configuration is loaded.
 func Instance() Logger {
 	once.Do(func() {
-		core := createCore()
-		zapLogger := zap.New(core, zap.AddCaller())
-		instance = &AppLogger{zapLogger.Sugar()}
+		core, err := createCore(&internal.Configuration{LogRedactedKeys: []string{"matrixUserId", "displayName"}})
+		if err != nil {
+			panic(err)
+		}
+		instance = &AppLogger{newZapLogger(core)}
 	})
 	return instance
 }
 
-func createCore() zapcore.Core {
-	encoder := createEncoder()
-	core := zapcore.NewTee(
-		zapcore.NewCore(encoder, zapcore.AddSync(os.Stdout), getLevelEnablerFunc(zap.InfoLevel)),
-		zapcore.NewCore(encoder, zapcore.AddSync(os.Stdout), getLevelEnablerFunc(zap.DebugLevel)),
-		zapcore.NewCore(encoder, zapcore.AddSync(os.Stdout), getLevelEnablerFunc(zap.WarnLevel)),
-		zapcore.NewCore(encoder, zapcore.AddSync(os.Stdout), getLevelEnablerFunc(zap.ErrorLevel)),
-		zapcore.NewCore(encoder, zapcore.AddSync(os.Stdout), getLevelEnablerFunc(zap.FatalLevel)),
-		zapcore.NewCore(encoder, zapcore.AddSync(os.Stdout), getLevelEnablerFunc(zap.DPanicLevel)),
-		zapcore.NewCore(encoder, zapcore.AddSync(os.Stdout), getLevelEnablerFunc(zap.PanicLevel)),
-	)
-	return core
+// Configure applies LOG_FORMAT, LOG_LEVEL, LOG_SAMPLING and LOG_REDACTED_KEYS to the instance. It is called once the
+// configuration has been loaded, before any other goroutine logs.
+func Configure(config *internal.Configuration) error {
+	core, err := createCore(config)
+	if err != nil {
+		return err
+	}
+	Instance()
+	instance.logger = newZapLogger(core)
+	return nil
+}
+
+func newZapLogger(core zapcore.Core) *zap.SugaredLogger {
+	return zap.New(core, zap.AddCaller(), zap.AddCallerSkip(1)).Sugar()
+}
+
+type loggerContextKey struct{}
+
+// WithContext returns a context carrying logger, e.g. one with the fields of a request.
+func WithContext(ctx context.Context, logger Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey{}, logger)
+}
+
+// From returns the logger of a context, or the instance if the context carries none.
+func From(ctx context.Context) Logger {
+	if logger, found := ctx.Value(loggerContextKey{}).(Logger); found {
+		return logger
+	}
+	return Instance()
+}
+
+// Level returns the minimum level of logged entries, e.g. "info".
+func Level() string {
+	return level.Level().String()
 }
 
-func createEncoder() zapcore.Encoder {
+// SetLevel changes the minimum level of logged entries until the next restart.
+func SetLevel(text string) error {
+	parsed, err := zapcore.ParseLevel(text)
+	if err != nil {
+		return err
+	}
+	level.SetLevel(parsed)
+	return nil
+}
+
+func createCore(config *internal.Configuration) (zapcore.Core, error) {
+	if config.LogLevel != "" {
+		if err := SetLevel(config.LogLevel); err != nil {
+			return nil, fmt.Errorf("LOG_LEVEL %s is not a valid level", config.LogLevel)
+		}
+	}
+
+	var encoder zapcore.Encoder
+	switch config.LogFormat {
+	case "", internal.LogFormatConsole:
+		encoder = createConsoleEncoder()
+	case internal.LogFormatJson:
+		encoder = createJsonEncoder()
+	default:
+		return nil, fmt.Errorf("LOG_FORMAT %s is neither %s nor %s", config.LogFormat, internal.LogFormatConsole, internal.LogFormatJson)
+	}
+	core := newRedactingCore(zapcore.NewCore(encoder, zapcore.Lock(os.Stdout), level), config.LogRedactedKeys)
+
+	// e.g. 100/1s logs the first 100 entries with the same message and level per second and drops the others
+	if config.LogSampling.Enabled() {
+		core = zapcore.NewSamplerWithOptions(core, config.LogSampling.Per, config.LogSampling.Requests, 0)
+	}
+	return core, nil
+}
+
+func createConsoleEncoder() zapcore.Encoder {
 	encoder := zapcore.NewConsoleEncoder(zapcore.EncoderConfig{
 		MessageKey:  "msg",
 		LevelKey:    "level",
@@ -85,37 +158,65 @@ func createEncoder() zapcore.Encoder {
 	return encoder
 }
 
-func getLevelEnablerFunc(level zapcore.Level) zap.LevelEnablerFunc {
-	return func(lvl zapcore.Level) bool {
-		return lvl == level
-	}
+func createJsonEncoder() zapcore.Encoder {
+	return zapcore.NewJSONEncoder(zapcore.EncoderConfig{
+		MessageKey:     "msg",
+		LevelKey:       "level",
+		EncodeLevel:    zapcore.LowercaseLevelEncoder,
+		TimeKey:        "ts",
+		EncodeTime:     zapcore.RFC3339NanoTimeEncoder,
+		CallerKey:      "caller",
+		EncodeCaller:   zapcore.ShortCallerEncoder,
+		StacktraceKey:  "stacktrace",
+		EncodeDuration: zapcore.StringDurationEncoder,
+	})
 }
 
 func (log *AppLogger) Info(args ...interface{}) {
-	log.logger.Info(args)
+	log.logger.Info(args...)
 }
 
 func (log *AppLogger) Debug(args ...interface{}) {
-	log.logger.Debug(args)
+	log.logger.Debug(args...)
 }
 
 func (log *AppLogger) Warn(args ...interface{}) {
-	log.logger.Warn(args)
+	log.logger.Warn(args...)
 }
 
 func (log *AppLogger) Error(args ...interface{}) {
-	log.logger.Error(args)
+	log.logger.Error(args...)
 }
 func (log *AppLogger) Fatal(args ...interface{}) {
-	log.logger.Fatal(args)
+	log.logger.Fatal(args...)
 }
 
 func (log *AppLogger) DPanic(args ...interface{}) {
-	log.logger.DPanic(args)
+	log.logger.DPanic(args...)
 }
 
 func (log *AppLogger) Panic(args ...interface{}) {
-	log.logger.Panic(args)
+	log.logger.Panic(args...)
+}
+
+func (log *AppLogger) Debugw(message string, keysAndValues ...interface{}) {
+	log.logger.Debugw(message, keysAndValues...)
+}
+
+func (log *AppLogger) Infow(message string, keysAndValues ...interface{}) {
+	log.logger.Infow(message, keysAndValues...)
+}
+
+func (log *AppLogger) Warnw(message string, keysAndValues ...interface{}) {
+	log.logger.Warnw(message, keysAndValues...)
+}
+
+func (log *AppLogger) Errorw(message string, keysAndValues ...interface{}) {
+	log.logger.Errorw(message, keysAndValues...)
+}
+
+func (log *AppLogger) With(keysAndValues ...interface{}) Logger {
+	return &AppLogger{log.logger.With(keysAndValues...)}
 }
 
 func (log *AppLogger) OnExit() {
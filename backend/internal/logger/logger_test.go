@@ -0,0 +1,42 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package logger
+
+import (
+	"feedback/internal"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+	"testing"
+)
+
+func TestCreateCore(t *testing.T) {
+	defer SetLevel(Level())
+
+	core, err := createCore(&internal.Configuration{LogFormat: internal.LogFormatJson, LogLevel: "warn"})
+	assert.Nil(t, err)
+	assert.False(t, core.Enabled(zapcore.InfoLevel))
+	assert.True(t, core.Enabled(zapcore.WarnLevel))
+
+	assert.Nil(t, SetLevel("debug"))
+	assert.True(t, core.Enabled(zapcore.DebugLevel), "the level can be changed at runtime")
+
+	_, err = createCore(&internal.Configuration{LogFormat: "xml"})
+	assert.EqualError(t, err, "LOG_FORMAT xml is neither console nor json")
+	_, err = createCore(&internal.Configuration{LogLevel: "verbose"})
+	assert.EqualError(t, err, "LOG_LEVEL verbose is not a valid level")
+}
@@ -0,0 +1,142 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package logger
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces secrets and the values of redacted keys in log entries.
+const Redacted = "[REDACTED]"
+
+// AlwaysRedactedKeys are redacted in addition to those of LOG_REDACTED_KEYS.
+var AlwaysRedactedKeys = []string{"authorization", "cookie", "set-cookie"}
+
+var (
+	// credentials of an Authorization header, e.g. "Bearer eyJ..."
+	credentialsPattern = regexp.MustCompile(`(?i)\b(bearer|basic)\s+[^\s"',;]+`)
+	// JWTs, whose header always starts with {" encoded as eyJ
+	jwtPattern = regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	// API keys of the admin API
+	apiKeyPattern = regexp.MustCompile(`fbk_[A-Za-z0-9_-]+`)
+)
+
+// redactString replaces credentials, JWTs and API keys in a message or field.
+func redactString(value string) string {
+	value = credentialsPattern.ReplaceAllString(value, "${1} "+Redacted)
+	value = jwtPattern.ReplaceAllString(value, Redacted)
+	return apiKeyPattern.ReplaceAllString(value, Redacted)
+}
+
+// redactingCore redacts the entries of the core it wraps, so that secrets don't end up in the logs even if they are
+// part of an error or of a logged value.
+type redactingCore struct {
+	zapcore.Core
+	keys map[string]bool
+}
+
+func newRedactingCore(core zapcore.Core, keys []string) zapcore.Core {
+	redactedKeys := map[string]bool{}
+	for _, key := range append(keys, AlwaysRedactedKeys...) {
+		if key = strings.TrimSpace(key); key != "" {
+			redactedKeys[strings.ToLower(key)] = true
+		}
+	}
+	return &redactingCore{core, redactedKeys}
+}
+
+func (core *redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactingCore{core.Core.With(core.redact(fields)), core.keys}
+}
+
+func (core *redactingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if core.Enabled(entry.Level) {
+		return checked.AddCore(entry, core)
+	}
+	return checked
+}
+
+func (core *redactingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = redactString(entry.Message)
+	return core.Core.Write(entry, core.redact(fields))
+}
+
+func (core *redactingCore) redact(fields []zapcore.Field) []zapcore.Field {
+	redacted := make([]zapcore.Field, len(fields))
+	for i, field := range fields {
+		redacted[i] = core.redactField(field)
+	}
+	return redacted
+}
+
+func (core *redactingCore) redactField(field zapcore.Field) zapcore.Field {
+	if core.keys[strings.ToLower(field.Key)] {
+		return zap.String(field.Key, Redacted)
+	}
+	switch field.Type {
+	case zapcore.StringType:
+		return zap.String(field.Key, redactString(field.String))
+	case zapcore.ErrorType:
+		return zap.String(field.Key, redactString(field.Interface.(error).Error()))
+	case zapcore.StringerType:
+		return zap.String(field.Key, redactString(field.Interface.(fmt.Stringer).String()))
+	case zapcore.ReflectType:
+		return zap.Any(field.Key, core.redactValue(field.Interface))
+	}
+	return field
+}
+
+// redactValue redacts maps with string keys, such as metadata and headers, and strings. Other values are logged as
+// they are.
+func (core *redactingCore) redactValue(value interface{}) interface{} {
+	reflected := reflect.ValueOf(value)
+	switch reflected.Kind() {
+	case reflect.String:
+		return redactString(reflected.String())
+	case reflect.Slice:
+		if reflected.Type().Elem().Kind() != reflect.String {
+			return value
+		}
+		redacted := make([]string, reflected.Len())
+		for i := range redacted {
+			redacted[i] = redactString(reflected.Index(i).String())
+		}
+		return redacted
+	case reflect.Map:
+		if reflected.Type().Key().Kind() != reflect.String {
+			return value
+		}
+		redacted := make(map[string]interface{}, reflected.Len())
+		entries := reflected.MapRange()
+		for entries.Next() {
+			key := entries.Key().String()
+			if core.keys[strings.ToLower(key)] {
+				redacted[key] = Redacted
+			} else {
+				redacted[key] = core.redactValue(entries.Value().Interface())
+			}
+		}
+		return redacted
+	}
+	return value
+}
@@ -0,0 +1,181 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package metrics
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const namespace = "feedback"
+
+// UnmatchedRoute labels requests that match no route, so scans for arbitrary paths share a single series.
+const UnmatchedRoute = "unmatched"
+
+// Reasons for rejected tokens
+const (
+	ReasonUserNotValid      = "user_not_valid"
+	ReasonVerificationError = "verification_error"
+	ReasonChallenge         = "challenge"
+	ReasonInvalid           = "invalid"
+	ReasonExpired           = "expired"
+	ReasonRevoked           = "revoked"
+	ReasonUsed              = "used"
+	ReasonUnregistered      = "unregistered"
+	ReasonOtherMeeting      = "other_meeting"
+)
+
+// Registry holds the metrics of the backend and of the Go runtime. Subsystems register collectors of their own.
+var Registry = prometheus.NewRegistry()
+
+var (
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status.",
+	}, []string{"route", "method", "status"})
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of HTTP requests by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+	tokensIssued = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tokens_issued_total",
+		Help:      "Feedback JWTs issued to Matrix users and anonymous guests.",
+	}, []string{"kind"})
+	tokensRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tokens_rejected_total",
+		Help:      "Token requests and feedback submissions rejected by reason.",
+	}, []string{"reason"})
+	feedbackStored = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "feedback_stored_total",
+		Help:      "Feedback stored or updated by rating.",
+	}, []string{"rating"})
+	uvsDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "uvs_request_duration_seconds",
+		Help:      "Duration of calls to the user verification service by result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"result"})
+	configReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_reloads_total",
+		Help:      "Configuration reloads by result.",
+	}, []string{"result"})
+	configReloadSuccessful = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "config_last_reload_successful",
+		Help:      "Whether the last configuration reload was activated (1) or rejected as invalid (0).",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requests, requestDuration, tokensIssued, tokensRejected, feedbackStored, uvsDuration,
+		configReloads, configReloadSuccessful,
+	)
+	configReloadSuccessful.Set(1)
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Instrument counts the requests of a mux router by their route template, so paths with IDs don't add series. Mux
+// doesn't run middlewares for requests that match no route, so its NotFoundHandler and MethodNotAllowedHandler are
+// to be wrapped as well.
+func Instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		route := UnmatchedRoute
+		if current := mux.CurrentRoute(request); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		recorder := &statusRecorder{ResponseWriter: writer, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(recorder, request)
+		requestDuration.WithLabelValues(route, request.Method).Observe(time.Since(start).Seconds())
+		requests.WithLabelValues(route, request.Method, strconv.Itoa(recorder.status)).Inc()
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func TokenIssued(anonymous bool) {
+	kind := "matrix"
+	if anonymous {
+		kind = "anonymous"
+	}
+	tokensIssued.WithLabelValues(kind).Inc()
+}
+
+func TokenRejected(reason string) {
+	tokensRejected.WithLabelValues(reason).Inc()
+}
+
+// FeedbackStored counts feedback by its rating. Ratings outside of what Jitsi sends are counted as other, so that
+// clients can't add arbitrary series.
+func FeedbackStored(rating int) {
+	label := "other"
+	if rating >= -1 && rating <= 5 {
+		label = strconv.Itoa(rating)
+	}
+	feedbackStored.WithLabelValues(label).Inc()
+}
+
+// UvsRequest observes a call to the user verification service that started at start.
+func UvsRequest(start time.Time, succeeded bool) {
+	result := "ok"
+	if !succeeded {
+		result = "error"
+	}
+	uvsDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+}
+
+// ConfigReloaded counts a reload of the configuration, which was either activated or rejected.
+func ConfigReloaded(succeeded bool) {
+	result := "ok"
+	successful := 1.0
+	if !succeeded {
+		result = "error"
+		successful = 0
+	}
+	configReloads.WithLabelValues(result).Inc()
+	configReloadSuccessful.Set(successful)
+}
@@ -0,0 +1,71 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package metrics
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInstrument_LabelsByRouteTemplate(t *testing.T) {
+	router := mux.NewRouter()
+	router.HandleFunc("/items/{id}", func(writer http.ResponseWriter, request *http.Request) {
+		http.NotFound(writer, request)
+	})
+	router.Use(Instrument)
+
+	for _, path := range []string{"/items/1", "/items/2"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(requests.WithLabelValues("/items/{id}", http.MethodGet, "404")))
+	assert.Equal(t, 1, testutil.CollectAndCount(requestDuration))
+}
+
+func TestInstrument_LabelsUnmatchedRequests(t *testing.T) {
+	router := mux.NewRouter()
+	router.HandleFunc("/things", func(writer http.ResponseWriter, request *http.Request) {}).Methods(http.MethodGet)
+	router.NotFoundHandler = Instrument(http.NotFoundHandler())
+	router.MethodNotAllowedHandler = Instrument(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/.env", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/things", nil))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(requests.WithLabelValues(UnmatchedRoute, http.MethodGet, "404")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(requests.WithLabelValues(UnmatchedRoute, http.MethodDelete, "405")))
+}
+
+func TestFeedbackStored_LimitsRatingLabels(t *testing.T) {
+	FeedbackStored(5)
+	FeedbackStored(1000)
+	FeedbackStored(-1000)
+
+	assert.Nil(t, testutil.CollectAndCompare(feedbackStored, strings.NewReader(`
+# HELP feedback_feedback_stored_total Feedback stored or updated by rating.
+# TYPE feedback_feedback_stored_total counter
+feedback_feedback_stored_total{rating="5"} 1
+feedback_feedback_stored_total{rating="other"} 2
+`)))
+}
@@ -0,0 +1,104 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package ratelimit
+
+import (
+	"feedback/internal"
+	"math"
+	"sync"
+	"time"
+)
+
+// Store keeps one token bucket per key. A bucket holds up to limit.Requests tokens and is refilled at a rate of
+// limit.Requests per limit.Per.
+type Store interface {
+	// Allow takes a token from the bucket of key. If the bucket is empty, it returns false and the time until the
+	// next token is available.
+	Allow(key string, limit internal.RateLimit) (bool, time.Duration)
+}
+
+// Bucket is a token bucket as of its last use.
+type Bucket struct {
+	Tokens float64
+	Last   time.Time
+	FullAt time.Time
+}
+
+func NewBucket(now time.Time, limit internal.RateLimit) *Bucket {
+	return &Bucket{Tokens: float64(limit.Requests), Last: now, FullAt: now}
+}
+
+// Take refills the bucket for the time passed since its last use and takes a token if there is one. Otherwise, it
+// returns the time until the next token is available.
+func (bucket *Bucket) Take(now time.Time, limit internal.RateLimit) (bool, time.Duration) {
+	capacity := float64(limit.Requests)
+	rate := capacity / limit.Per.Seconds()
+
+	bucket.Tokens = math.Min(capacity, bucket.Tokens+now.Sub(bucket.Last).Seconds()*rate)
+	bucket.Last = now
+	if bucket.Tokens < 1 {
+		return false, time.Duration((1 - bucket.Tokens) / rate * float64(time.Second))
+	}
+
+	bucket.Tokens--
+	bucket.FullAt = now.Add(time.Duration((capacity - bucket.Tokens) / rate * float64(time.Second)))
+	return true, 0
+}
+
+type MemoryStore struct {
+	mutex     sync.Mutex
+	buckets   map[string]*Bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*Bucket{}, lastSweep: time.Now(), now: time.Now}
+}
+
+func (store *MemoryStore) Allow(key string, limit internal.RateLimit) (bool, time.Duration) {
+	if !limit.Enabled() {
+		return true, 0
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := store.now()
+	store.sweep(now)
+
+	current, found := store.buckets[key]
+	if !found {
+		current = NewBucket(now, limit)
+		store.buckets[key] = current
+	}
+	return current.Take(now, limit)
+}
+
+// sweep forgets buckets that are full again, since they behave like buckets that have never been used.
+func (store *MemoryStore) sweep(now time.Time) {
+	if now.Sub(store.lastSweep) < time.Minute {
+		return
+	}
+	store.lastSweep = now
+	for key, current := range store.buckets {
+		if !now.Before(current.FullAt) {
+			delete(store.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package ratelimit
+
+import (
+	"feedback/internal"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore_BurstAndRefill(t *testing.T) {
+	now := time.Date(2022, 12, 7, 9, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	limit := internal.RateLimit{Requests: 2, Per: time.Minute}
+
+	for i := 0; i < 2; i++ {
+		allowed, _ := store.Allow("someKey", limit)
+		assert.True(t, allowed)
+	}
+	allowed, retryAfter := store.Allow("someKey", limit)
+	assert.False(t, allowed)
+	assert.Equal(t, 30*time.Second, retryAfter)
+
+	allowed, _ = store.Allow("otherKey", limit)
+	assert.True(t, allowed)
+
+	now = now.Add(30 * time.Second)
+	allowed, _ = store.Allow("someKey", limit)
+	assert.True(t, allowed)
+	allowed, _ = store.Allow("someKey", limit)
+	assert.False(t, allowed)
+}
+
+func TestMemoryStore_Unlimited(t *testing.T) {
+	store := NewMemoryStore()
+	for i := 0; i < 100; i++ {
+		allowed, _ := store.Allow("someKey", internal.RateLimit{})
+		assert.True(t, allowed)
+	}
+}
+
+func TestClientIp(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	trustedProxies := []*net.IPNet{proxies}
+
+	for _, test := range []struct {
+		remoteAddr   string
+		forwardedFor string
+		expected     string
+	}{
+		{"192.0.2.1:1234", "", "192.0.2.1"},
+		{"192.0.2.1:1234", "198.51.100.1", "192.0.2.1"},
+		{"10.0.0.1:1234", "198.51.100.1", "198.51.100.1"},
+		{"10.0.0.1:1234", "198.51.100.2, 198.51.100.1, 10.0.0.2", "198.51.100.1"},
+		{"10.0.0.1:1234", "garbage, 10.0.0.2", "10.0.0.2"},
+		{"10.0.0.1:1234", "", "10.0.0.1"},
+	} {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.RemoteAddr = test.remoteAddr
+		if test.forwardedFor != "" {
+			request.Header.Set("X-Forwarded-For", test.forwardedFor)
+		}
+		assert.Equal(t, test.expected, ClientIp(request, trustedProxies), test.forwardedFor)
+	}
+}
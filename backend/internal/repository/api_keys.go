@@ -0,0 +1,68 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package repository
+
+import (
+	"errors"
+	"feedback/internal/auth"
+	"strings"
+	"time"
+)
+
+var ErrApiKeyNotFound = errors.New("API key not found")
+
+func (repo *Repository) CreateApiKey(apiKey *ApiKey) error {
+	return repo.db.Create(apiKey).Error
+}
+
+func (repo *Repository) ListApiKeys() ([]ApiKey, error) {
+	var apiKeys []ApiKey
+	err := repo.db.Order("id").Find(&apiKeys).Error
+	return apiKeys, err
+}
+
+func (repo *Repository) RevokeApiKey(id uint) error {
+	result := repo.db.Model(&ApiKey{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrApiKeyNotFound
+	}
+	return nil
+}
+
+// UseApiKey implements auth.ApiKeyStore.
+func (repo *Repository) UseApiKey(keyHash string) (*auth.Admin, error) {
+	var apiKey ApiKey
+	result := repo.db.Where("key_hash = ? AND revoked_at IS NULL", keyHash).Limit(1).Find(&apiKey)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrApiKeyNotFound
+	}
+
+	err := repo.db.Model(&apiKey).Update("last_used_at", time.Now()).Error
+	if err != nil {
+		log.Errorw("recording use of API key failed", "error", err)
+	}
+	return &auth.Admin{Name: apiKey.Name, Scopes: strings.Split(apiKey.Scopes, ","), Tenant: apiKey.Tenant, Global: apiKey.Global}, nil
+}
@@ -0,0 +1,176 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"time"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditFeedbackRead      = "feedback.read"
+	AuditFeedbackExport    = "feedback.export"
+	AuditFeedbackDelete    = "feedback.delete"
+	AuditFeedbackReencrypt = "feedback.reencrypt"
+	AuditTokensRevoke      = "tokens.revoke"
+	AuditTokenMint         = "token.mint"
+	AuditAuditRead         = "audit.read"
+	AuditStatisticsRead    = "statistics.read"
+	AuditAdminLogin        = "admin.login"
+	AuditApiKeyCreate      = "api-key.create"
+	AuditApiKeyRevoke      = "api-key.revoke"
+	AuditLogLevelChange    = "log-level.change"
+)
+
+// GenesisHash is the previous hash of the first audit entry.
+var GenesisHash = hex.EncodeToString(make([]byte, sha256.Size))
+
+var ErrAuditChainBroken = errors.New("audit log has been tampered with")
+
+// AuditFilter selects audit entries. Empty fields don't filter, except for the tenant, whose empty value selects the
+// entries of the default tenant.
+type AuditFilter struct {
+	Tenant string
+	From   *time.Time
+	To     *time.Time
+	Actor  string
+	Action string
+	Limit  int
+	Offset int
+}
+
+// ComputeHash hashes the entry together with the hash of its predecessor, so that changing, removing or reordering
+// entries breaks the chain. The tenant is only hashed if set, so entries written before tenants existed still verify.
+func (entry *AuditEntry) ComputeHash() string {
+	fields := []string{
+		entry.PreviousHash,
+		fmt.Sprint(entry.Sequence),
+		entry.CreatedAt.UTC().Format(time.RFC3339Nano),
+		entry.Actor,
+		entry.Action,
+		entry.Parameters,
+		fmt.Sprint(entry.AffectedRows),
+	}
+	if entry.Tenant != "" {
+		fields = append(fields, entry.Tenant)
+	}
+
+	hash := sha256.New()
+	for _, field := range fields {
+		hash.Write([]byte(fmt.Sprintf("%d:%s;", len(field), field)))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// AppendAudit chains the entry to the last one and stores it. Appends are serialized by a table lock, which is held
+// until the surrounding transaction ends.
+func (repo *Repository) AppendAudit(entry *AuditEntry) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("LOCK TABLE audit_log IN SHARE ROW EXCLUSIVE MODE").Error
+		if err != nil {
+			return err
+		}
+
+		var last AuditEntry
+		result := tx.Order("sequence desc").Limit(1).Find(&last)
+		if result.Error != nil {
+			return result.Error
+		}
+		entry.PreviousHash = GenesisHash
+		entry.Sequence = 1
+		if result.RowsAffected > 0 {
+			entry.PreviousHash = last.Hash
+			entry.Sequence = last.Sequence + 1
+		}
+		// postgres keeps microseconds, which the hash has to survive
+		entry.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+		entry.Hash = entry.ComputeHash()
+		return tx.Create(entry).Error
+	})
+}
+
+func (repo *Repository) FindAuditEntries(filter AuditFilter) ([]AuditEntry, error) {
+	db := repo.db.Where("tenant = ?", filter.Tenant)
+	if filter.From != nil {
+		db = db.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		db = db.Where("created_at < ?", *filter.To)
+	}
+	if filter.Actor != "" {
+		db = db.Where("actor = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		db = db.Where("action = ?", filter.Action)
+	}
+	if filter.Limit > 0 {
+		db = db.Limit(filter.Limit)
+	}
+
+	var entries []AuditEntry
+	err := db.Order("sequence").Offset(filter.Offset).Find(&entries).Error
+	return entries, err
+}
+
+func (repo *Repository) FindAuditEntriesByHash(hash string) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	err := repo.db.Where("hash = ?", hash).Find(&entries).Error
+	return entries, err
+}
+
+// VerifyAudit walks the whole chain and returns the number of entries and the hash of the last one. Comparing that
+// hash with one noted earlier also detects entries cut off at the end.
+func (repo *Repository) VerifyAudit() (int64, string, error) {
+	var count int64
+	previous := AuditEntry{Hash: GenesisHash}
+	for {
+		var entries []AuditEntry
+		err := repo.db.Where("sequence > ?", previous.Sequence).Order("sequence").Limit(ExportBatchSize).Find(&entries).Error
+		if err != nil {
+			return count, previous.Hash, err
+		}
+		for _, entry := range entries {
+			if err := verifyAuditEntry(previous, entry); err != nil {
+				return count, previous.Hash, err
+			}
+			previous = entry
+			count++
+		}
+		if len(entries) < ExportBatchSize {
+			return count, previous.Hash, nil
+		}
+	}
+}
+
+func verifyAuditEntry(previous AuditEntry, entry AuditEntry) error {
+	if entry.Sequence != previous.Sequence+1 {
+		return fmt.Errorf("%w: entries %d to %d are missing", ErrAuditChainBroken, previous.Sequence+1, entry.Sequence-1)
+	}
+	if entry.PreviousHash != previous.Hash {
+		return fmt.Errorf("%w: entry %d does not follow entry %d", ErrAuditChainBroken, entry.Sequence, previous.Sequence)
+	}
+	if entry.ComputeHash() != entry.Hash {
+		return fmt.Errorf("%w: entry %d has been modified", ErrAuditChainBroken, entry.Sequence)
+	}
+	return nil
+}
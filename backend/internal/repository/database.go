@@ -23,6 +23,7 @@ import (
 	"fmt"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/plugin/opentelemetry/tracing"
 )
 
 const (
@@ -32,8 +33,12 @@ const (
 func createGormDBConnection(config *internal.Configuration) (*gorm.DB, error) {
 	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=%s",
 		config.DbHost, config.DbUser, config.DbPassword, config.DbName, config.DbPort, config.Sslmode)
-	return gorm.Open(postgres.Open(dsn), &gorm.Config{})
-
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+	// statements are traced without their variables, which may hold comments and metadata
+	return db, db.Use(tracing.NewPlugin(tracing.WithDBName(config.DbName), tracing.WithoutQueryVariables(), tracing.WithoutMetrics()))
 }
 
 func createPlainSqlConnection(config *internal.Configuration) (*sql.DB, error) {
@@ -0,0 +1,167 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"feedback/internal/encryption"
+	"gorm.io/gorm"
+)
+
+const (
+	commentField        = "rating_comment"
+	metadataFieldPrefix = "metadata."
+)
+
+var ErrEncryptionNotConfigured = errors.New("feedback is encrypted, but ENCRYPTION_KEYS is not set")
+
+// encryptFeedback encrypts the comment and the metadata of ENCRYPTED_METADATA with a new data key, if encryption is
+// configured.
+func (repo *Repository) encryptFeedback(feedback *Feedback) error {
+	if repo.keyring == nil {
+		return nil
+	}
+	dataKey, err := repo.keyring.NewDataKey()
+	if err != nil {
+		return err
+	}
+
+	if feedback.RatingComment != "" {
+		if feedback.RatingComment, err = dataKey.Encrypt(commentField, feedback.RatingComment); err != nil {
+			return err
+		}
+	}
+	for _, key := range repo.config.EncryptedMetadata {
+		value, found := feedback.Metadata[key]
+		if !found {
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		if feedback.Metadata[key], err = dataKey.Encrypt(metadataFieldPrefix+key, string(encoded)); err != nil {
+			return err
+		}
+	}
+	feedback.DataKey = dataKey.Wrapped
+	feedback.KeyVersion = dataKey.Version
+	return nil
+}
+
+// decryptFeedback decrypts the comment and the metadata of ENCRYPTED_METADATA of feedback read from the database.
+func (repo *Repository) decryptFeedback(feedback *Feedback) error {
+	if feedback.KeyVersion == "" {
+		return nil
+	}
+	if repo.keyring == nil {
+		return ErrEncryptionNotConfigured
+	}
+	dataKey, err := repo.keyring.Unwrap(feedback.KeyVersion, feedback.DataKey)
+	if err != nil {
+		return err
+	}
+
+	if feedback.RatingComment, err = dataKey.Decrypt(commentField, feedback.RatingComment); err != nil {
+		return err
+	}
+	for _, key := range repo.config.EncryptedMetadata {
+		encrypted, ok := feedback.Metadata[key].(string)
+		if !ok || !encryption.IsEncrypted(encrypted) {
+			continue
+		}
+		decrypted, err := dataKey.Decrypt(metadataFieldPrefix+key, encrypted)
+		if err != nil {
+			return err
+		}
+		var decoded interface{}
+		if err = json.Unmarshal([]byte(decrypted), &decoded); err != nil {
+			return err
+		}
+		feedback.Metadata[key] = decoded
+	}
+	return nil
+}
+
+// ActiveKeyVersion returns the version of the key new data keys are wrapped with, or "" without encryption.
+func (repo *Repository) ActiveKeyVersion() string {
+	if repo.keyring == nil {
+		return ""
+	}
+	return repo.keyring.ActiveVersion()
+}
+
+// ReencryptFeedbacks brings all feedback to the active encryption key. Data keys of other versions are rewrapped,
+// which leaves the encrypted fields as they are, and feedback stored in plaintext is encrypted. It returns the number
+// of rows changed.
+func (repo *Repository) ReencryptFeedbacks() (int64, error) {
+	if repo.keyring == nil {
+		return 0, errors.New("ENCRYPTION_KEYS is not set")
+	}
+
+	var changed int64
+	var lastId uint
+	for {
+		var feedbacks []Feedback
+		err := repo.db.Where("id > ? AND key_version <> ?", lastId, repo.keyring.ActiveVersion()).
+			Order("id").Limit(ExportBatchSize).Find(&feedbacks).Error
+		if err != nil {
+			return changed, err
+		}
+
+		err = repo.db.Transaction(func(tx *gorm.DB) error {
+			for _, feedback := range feedbacks {
+				if err := repo.reencrypt(&feedback); err != nil {
+					return err
+				}
+				err := tx.Model(&feedback).Select("rating_comment", "metadata", "data_key", "key_version").Updates(&feedback).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return changed, err
+		}
+		changed += int64(len(feedbacks))
+
+		if len(feedbacks) < ExportBatchSize {
+			return changed, nil
+		}
+		lastId = feedbacks[len(feedbacks)-1].ID
+	}
+}
+
+func (repo *Repository) reencrypt(feedback *Feedback) error {
+	if feedback.KeyVersion == "" {
+		return repo.encryptFeedback(feedback)
+	}
+	dataKey, err := repo.keyring.Unwrap(feedback.KeyVersion, feedback.DataKey)
+	if err != nil {
+		return err
+	}
+	rewrapped, err := repo.keyring.Rewrap(dataKey)
+	if err != nil {
+		return err
+	}
+	feedback.DataKey = rewrapped.Wrapped
+	feedback.KeyVersion = rewrapped.Version
+	return nil
+}
@@ -0,0 +1,110 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package repository
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+// ExportBatchSize is the number of rows an export reads at once.
+const ExportBatchSize = 500
+
+// FeedbackFilter selects feedback for administrative reads, exports and deletes. Empty fields don't filter, except for
+// the tenant, whose empty value selects the feedback of the default tenant.
+type FeedbackFilter struct {
+	Tenant   string
+	From     *time.Time
+	To       *time.Time
+	TokenId  string
+	Metadata map[string]string
+	// Consent selects feedback whose user consented to this category, NoConsent feedback whose user did not.
+	Consent   string
+	NoConsent string
+	Limit     int
+	Offset    int
+}
+
+// IsEmpty tells whether the filter selects all feedback of the tenant.
+func (filter FeedbackFilter) IsEmpty() bool {
+	return filter.From == nil && filter.To == nil && filter.TokenId == "" && len(filter.Metadata) == 0 &&
+		filter.Consent == "" && filter.NoConsent == ""
+}
+
+func (filter FeedbackFilter) apply(db *gorm.DB) *gorm.DB {
+	db = db.Where("tenant = ?", filter.Tenant)
+	if filter.From != nil {
+		db = db.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		db = db.Where("created_at < ?", *filter.To)
+	}
+	if filter.TokenId != "" {
+		db = db.Where("token_id = ?", filter.TokenId)
+	}
+	for key, value := range filter.Metadata {
+		db = db.Where("metadata ->> ? = ?", key, value)
+	}
+	if filter.Consent != "" {
+		db = db.Where("? = any(consent)", filter.Consent)
+	}
+	if filter.NoConsent != "" {
+		db = db.Where("(consent is null or not (? = any(consent)))", filter.NoConsent)
+	}
+	return db
+}
+
+func (repo *Repository) FindFeedbacks(filter FeedbackFilter) ([]Feedback, error) {
+	var feedbacks []Feedback
+	db := filter.apply(repo.db).Order("id").Offset(filter.Offset)
+	if filter.Limit > 0 {
+		db = db.Limit(filter.Limit)
+	}
+	if err := db.Find(&feedbacks).Error; err != nil {
+		return nil, err
+	}
+	for i := range feedbacks {
+		if err := repo.decryptFeedback(&feedbacks[i]); err != nil {
+			return nil, err
+		}
+	}
+	return feedbacks, nil
+}
+
+// ExportFeedbacks passes all feedback selected by the filter to fn in batches, so exports don't load whole tables.
+func (repo *Repository) ExportFeedbacks(filter FeedbackFilter, fn func(batch []Feedback) error) error {
+	var feedbacks []Feedback
+	return filter.apply(repo.db).FindInBatches(&feedbacks, ExportBatchSize, func(tx *gorm.DB, batch int) error {
+		for i := range feedbacks {
+			if err := repo.decryptFeedback(&feedbacks[i]); err != nil {
+				return err
+			}
+		}
+		return fn(feedbacks)
+	}).Error
+}
+
+// DeleteFeedbacks deletes the feedback selected by the filter, which must not be empty.
+func (repo *Repository) DeleteFeedbacks(filter FeedbackFilter) (int64, error) {
+	if filter.IsEmpty() {
+		return 0, errors.New("refusing to delete all feedback without a filter")
+	}
+	result := filter.apply(repo.db).Delete(&Feedback{})
+	return result.RowsAffected, result.Error
+}
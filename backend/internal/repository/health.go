@@ -0,0 +1,67 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package repository
+
+import (
+	"context"
+	"feedback/internal/health"
+	"fmt"
+	"github.com/pressly/goose/v3"
+	"time"
+)
+
+// RegisterHealthChecks registers whether the database is reachable and all migrations have been applied.
+func (repo *Repository) RegisterHealthChecks(registry *health.Registry, timeout time.Duration) {
+	registry.Register("database", timeout, true, func(ctx context.Context) error {
+		db, err := repo.db.DB()
+		if err != nil {
+			return err
+		}
+		return db.PingContext(ctx)
+	})
+	registry.Register("migrations", timeout, true, func(ctx context.Context) error {
+		expected, applied, err := repo.migrationVersions()
+		if err != nil {
+			return err
+		}
+		if applied < expected {
+			return fmt.Errorf("migration %d of %d applied", applied, expected)
+		}
+		return nil
+	})
+}
+
+// migrationVersions returns the version of the latest embedded migration and of the latest applied one.
+func (repo *Repository) migrationVersions() (int64, int64, error) {
+	setupGoose()
+	embedded, err := goose.CollectMigrations("migrations", 0, goose.MaxVersion)
+	if err != nil {
+		return 0, 0, err
+	}
+	latest, err := embedded.Last()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	db, err := repo.db.DB()
+	if err != nil {
+		return 0, 0, err
+	}
+	applied, err := goose.GetDBVersion(db)
+	return latest.Version, applied, err
+}
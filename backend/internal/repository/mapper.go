@@ -18,10 +18,20 @@
 package repository
 
 import (
+	"encoding/json"
+	"errors"
+	"feedback/internal"
 	"feedback/internal/api"
+	"feedback/internal/auth"
+	"feedback/internal/consent"
+	"feedback/internal/tenant"
+	"fmt"
+	"github.com/lib/pq"
+	"strings"
+	"time"
 )
 
-func MapToFeedbackModel(feedback api.Feedback, tokenValue string) *Feedback {
+func MapToFeedbackModel(feedback api.Feedback, tokenId string, anonymous bool, tenant string, survey string) *Feedback {
 	var dbFeedback Feedback
 	dbFeedback.RatingComment = feedback.RatingComment
 	dbFeedback.Rating = feedback.Rating
@@ -29,7 +39,143 @@ func MapToFeedbackModel(feedback api.Feedback, tokenValue string) *Feedback {
 	for key, value := range feedback.Metadata {
 		dbFeedback.Metadata[key] = value
 	}
-	dbFeedback.Jwt = tokenValue
+	dbFeedback.TokenId = tokenId
+	dbFeedback.Anonymous = anonymous
+	dbFeedback.Tenant = tenant
+	dbFeedback.Survey = survey
+	dbFeedback.Consent = consent.Normalize(feedback.Consent)
 
 	return &dbFeedback
 }
+
+func MapToStoredFeedback(feedback Feedback) api.StoredFeedback {
+	return api.StoredFeedback{
+		Id:            feedback.ID,
+		CreatedAt:     feedback.CreatedAt,
+		Rating:        feedback.Rating,
+		RatingComment: feedback.RatingComment,
+		Metadata:      feedback.Metadata,
+		Anonymous:     feedback.Anonymous,
+		Survey:        feedback.Survey,
+		Consent:       MapToConsent(feedback.Consent),
+	}
+}
+
+// MapToConsent returns the consent stored with feedback, or nil if it was sent without consent.
+func MapToConsent(categories pq.StringArray) *api.Consent {
+	if categories == nil {
+		return nil
+	}
+	return &api.Consent{Categories: categories}
+}
+
+func MapToApiKeyModel(name string, key string, scopes []string, tenant string, global bool) *ApiKey {
+	return &ApiKey{
+		Name:      name,
+		KeyHash:   auth.HashApiKey(key),
+		KeyPrefix: key[:len(auth.ApiKeyPrefix)+8],
+		Scopes:    strings.Join(scopes, ","),
+		Tenant:    tenant,
+		Global:    global,
+	}
+}
+
+func MapToAuditEntry(actor string, action string, parameters interface{}, affectedRows int64, tenant string) (*AuditEntry, error) {
+	encoded, err := json.Marshal(parameters)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditEntry{Actor: actor, Action: action, Parameters: string(encoded), AffectedRows: affectedRows, Tenant: tenant}, nil
+}
+
+func MapToApiAuditEntry(entry AuditEntry) api.AuditEntry {
+	return api.AuditEntry{
+		Sequence:     entry.Sequence,
+		CreatedAt:    entry.CreatedAt,
+		Actor:        entry.Actor,
+		Action:       entry.Action,
+		Parameters:   json.RawMessage(entry.Parameters),
+		AffectedRows: entry.AffectedRows,
+		PreviousHash: entry.PreviousHash,
+		Hash:         entry.Hash,
+		Tenant:       entry.Tenant,
+	}
+}
+
+func MapToTokenModel(tokenId string, subject string, meetingId string, issuedAt int64, expiresAt int64, anonymous bool, tenant string) *Token {
+	return &Token{
+		TokenId:   tokenId,
+		Subject:   subject,
+		MeetingId: meetingId,
+		State:     TokenIssued,
+		IssuedAt:  time.Unix(issuedAt, 0),
+		ExpiresAt: time.Unix(expiresAt, 0),
+		Anonymous: anonymous,
+		Tenant:    tenant,
+	}
+}
+
+// MapToRevocationModel accepts exactly one of token ID, subject, Matrix user ID or issue time to revoke. The entry
+// expires once every token it matches has expired on its own.
+func MapToRevocationModel(revocation api.Revocation, tokenExpiry time.Duration, tenant string) (*Revocation, error) {
+	var dbRevocation Revocation
+	dbRevocation.Tenant = tenant
+	criteria := 0
+	dbRevocation.IssuedBefore = time.Now()
+
+	if revocation.TokenId != "" {
+		dbRevocation.TokenId = &revocation.TokenId
+		criteria++
+	}
+	if revocation.Subject != "" {
+		dbRevocation.Subject = &revocation.Subject
+		criteria++
+	}
+	if revocation.MatrixUserId != "" {
+		subject := auth.HashUserId(revocation.MatrixUserId)
+		dbRevocation.Subject = &subject
+		criteria++
+	}
+	if revocation.IssuedBefore != nil {
+		dbRevocation.IssuedBefore = *revocation.IssuedBefore
+		criteria++
+	}
+	if criteria != 1 {
+		return nil, errors.New("exactly one of token_id, subject, matrix_user_id or issued_before is required")
+	}
+
+	dbRevocation.ExpiresAt = dbRevocation.IssuedBefore.Add(tokenExpiry)
+	return &dbRevocation, nil
+}
+
+func MapToTenant(dbTenant Tenant) (tenant.Tenant, error) {
+	groupRoles, err := internal.ParseMap(dbTenant.AdminOidcGroupRoles)
+	if err != nil {
+		return tenant.Tenant{}, fmt.Errorf("admin_oidc_group_roles of tenant %s: %w", dbTenant.Id, err)
+	}
+	return tenant.Tenant{
+		Id:                   dbTenant.Id,
+		Hosts:                splitList(dbTenant.Hosts),
+		PathPrefix:           dbTenant.PathPrefix,
+		MatrixServerName:     dbTenant.MatrixServerName,
+		OidcValidationUrl:    dbTenant.OidcValidationUrl,
+		JwtSecret:            dbTenant.JwtSecret,
+		JwtKeys:              dbTenant.JwtKeys,
+		JwtRetiredKeys:       dbTenant.JwtRetiredKeys,
+		JwtSigningKeyId:      dbTenant.JwtSigningKeyId,
+		CorsAllowedOrigins:   splitList(dbTenant.CorsAllowedOrigins),
+		Survey:               dbTenant.Survey,
+		AdminOidcRedirectUrl: dbTenant.AdminOidcRedirectUrl,
+		AdminOidcGroupRoles:  groupRoles,
+	}, nil
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
@@ -0,0 +1,49 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package repository
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// RegisterMetrics registers the statistics of the connection pool and the version of the latest applied migration.
+func (repo *Repository) RegisterMetrics(registerer prometheus.Registerer) error {
+	db, err := repo.db.DB()
+	if err != nil {
+		return err
+	}
+	migrationVersion := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "feedback",
+		Name:      "migration_version",
+		Help:      "Version of the latest applied database migration, -1 if it can't be read.",
+	}, func() float64 {
+		_, applied, err := repo.migrationVersions()
+		if err != nil {
+			log.Errorw("reading the migration version failed", "error", err)
+			return -1
+		}
+		return float64(applied)
+	})
+	for _, collector := range []prometheus.Collector{collectors.NewDBStatsCollector(db, repo.config.DbName), migrationVersion} {
+		if err := registerer.Register(collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package repository
+
+import (
+	"database/sql"
+	"embed"
+	"feedback/internal/logger"
+	"github.com/pressly/goose/v3"
+	"path/filepath"
+	"sync"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// MigrationState tells whether a migration embedded in the binary has been applied.
+type MigrationState struct {
+	Version int64
+	Source  string
+	Applied bool
+}
+
+var gooseSetup sync.Once
+
+// setupGoose points goose, which is configured globally, to the embedded migrations.
+func setupGoose() {
+	gooseSetup.Do(func() {
+		goose.SetLogger(logger.GooseLoggerWrapper(log))
+		goose.SetBaseFS(migrations)
+		if err := goose.SetDialect("postgres"); err != nil {
+			panic(err)
+		}
+	})
+}
+
+// MigrateUp applies all pending migrations.
+func (repo *Repository) MigrateUp() error {
+	return repo.migrate(func(db *sql.DB) error {
+		return goose.Up(db, "migrations")
+	})
+}
+
+// MigrateDown rolls back the latest applied migration.
+func (repo *Repository) MigrateDown() error {
+	return repo.migrate(func(db *sql.DB) error {
+		return goose.Down(db, "migrations")
+	})
+}
+
+// MigrateRedo rolls back the latest applied migration and applies it again.
+func (repo *Repository) MigrateRedo() error {
+	return repo.migrate(func(db *sql.DB) error {
+		return goose.Redo(db, "migrations")
+	})
+}
+
+// MigrateTo applies or rolls back migrations until version is the latest applied one.
+func (repo *Repository) MigrateTo(version int64) error {
+	return repo.migrate(func(db *sql.DB) error {
+		current, err := goose.GetDBVersion(db)
+		if err != nil {
+			return err
+		}
+		if version < current {
+			return goose.DownTo(db, "migrations", version)
+		}
+		return goose.UpTo(db, "migrations", version)
+	})
+}
+
+// MigrationStates returns every embedded migration and whether it has been applied.
+func (repo *Repository) MigrationStates() ([]MigrationState, error) {
+	var states []MigrationState
+	err := repo.migrate(func(db *sql.DB) error {
+		embedded, err := goose.CollectMigrations("migrations", 0, goose.MaxVersion)
+		if err != nil {
+			return err
+		}
+		applied, err := goose.GetDBVersion(db)
+		if err != nil {
+			return err
+		}
+		for _, migration := range embedded {
+			states = append(states, MigrationState{migration.Version, filepath.Base(migration.Source), migration.Version <= applied})
+		}
+		return nil
+	})
+	return states, err
+}
+
+// migrate runs goose on a connection of its own, which is closed afterwards.
+func (repo *Repository) migrate(fn func(db *sql.DB) error) error {
+	db, err := createPlainSqlConnection(repo.config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	setupGoose()
+	return fn(db)
+}
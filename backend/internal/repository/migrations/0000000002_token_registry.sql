@@ -0,0 +1,34 @@
+-- +goose Up
+create table tokens
+(
+    token_id   varchar(64) primary key,
+    subject    varchar(64) not null,
+    meeting_id varchar(256),
+    state      varchar(16) not null,
+    issued_at  timestamp   not null,
+    expires_at timestamp   not null,
+    used_at    timestamp
+);
+
+CREATE INDEX idx_tokens_subject ON tokens(subject);
+CREATE INDEX idx_tokens_expires_at ON tokens(expires_at);
+
+alter table feedbacks add column token_id varchar(64);
+CREATE INDEX idx_feedbacks_token_id ON feedbacks(token_id);
+
+-- JWTs issued before the registry carry no jti, so their feedback is linked by the hash of the JWT before the raw JWTs
+-- are dropped.
+update feedbacks set token_id = encode(sha256(convert_to(jwt, 'UTF8')), 'hex') where jwt is not null;
+
+DROP INDEX idx_feedbacks_jwt;
+alter table feedbacks drop column jwt;
+
+-- +goose Down
+-- the raw JWTs are gone, so rolling back restores an empty column
+alter table feedbacks add column jwt varchar(512);
+CREATE INDEX idx_feedbacks_jwt ON feedbacks(jwt);
+
+DROP INDEX idx_feedbacks_token_id;
+alter table feedbacks drop column token_id;
+
+drop table tokens;
@@ -0,0 +1,15 @@
+-- +goose Up
+create table revocations
+(
+    id            serial primary key,
+    token_id      varchar(64),
+    subject       varchar(64),
+    issued_before timestamp not null,
+    expires_at    timestamp not null,
+    created_at    timestamp not null
+);
+
+CREATE INDEX idx_revocations_expires_at ON revocations(expires_at);
+
+-- +goose Down
+drop table revocations;
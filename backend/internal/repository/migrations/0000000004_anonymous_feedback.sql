@@ -0,0 +1,7 @@
+-- +goose Up
+alter table tokens add column anonymous boolean not null default false;
+alter table feedbacks add column anonymous boolean not null default false;
+
+-- +goose Down
+alter table feedbacks drop column anonymous;
+alter table tokens drop column anonymous;
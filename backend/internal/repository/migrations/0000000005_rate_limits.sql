@@ -0,0 +1,13 @@
+-- +goose Up
+create table rate_limits
+(
+    key     varchar(512) primary key,
+    tokens  double precision not null,
+    last    timestamp        not null,
+    full_at timestamp        not null
+);
+
+CREATE INDEX idx_rate_limits_full_at ON rate_limits(full_at);
+
+-- +goose Down
+drop table rate_limits;
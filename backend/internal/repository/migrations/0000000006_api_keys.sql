@@ -0,0 +1,17 @@
+-- +goose Up
+create table api_keys
+(
+    id           serial primary key,
+    name         varchar(255) not null,
+    key_hash     varchar(64)  not null,
+    key_prefix   varchar(16)  not null,
+    scopes       varchar(255) not null,
+    last_used_at timestamp,
+    revoked_at   timestamp,
+    created_at   timestamp    not null
+);
+
+CREATE UNIQUE INDEX idx_api_keys_key_hash ON api_keys(key_hash);
+
+-- +goose Down
+drop table api_keys;
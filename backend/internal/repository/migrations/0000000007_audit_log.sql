@@ -0,0 +1,35 @@
+-- +goose Up
+create table audit_log
+(
+    id            serial primary key,
+    sequence      bigint       not null,
+    created_at    timestamp    not null,
+    actor         varchar(255) not null,
+    action        varchar(64)  not null,
+    parameters    text         not null,
+    affected_rows bigint       not null,
+    previous_hash varchar(64)  not null,
+    hash          varchar(64)  not null
+);
+
+CREATE UNIQUE INDEX idx_audit_log_sequence ON audit_log(sequence);
+CREATE INDEX idx_audit_log_created_at ON audit_log(created_at);
+
+-- +goose StatementBegin
+create function audit_log_append_only() returns trigger as
+$$
+begin
+    raise exception 'audit_log is append-only';
+end;
+$$ language plpgsql;
+-- +goose StatementEnd
+
+create trigger audit_log_append_only
+    before update or delete or truncate
+    on audit_log
+    for each statement
+execute function audit_log_append_only();
+
+-- +goose Down
+drop table audit_log;
+drop function audit_log_append_only();
@@ -0,0 +1,34 @@
+-- +goose Up
+create table tenants
+(
+    id                   varchar(64) primary key,
+    hosts                text         not null default '',
+    path_prefix          varchar(255) not null default '',
+    matrix_server_name   varchar(255) not null default '',
+    oidc_validation_url  varchar(255) not null default '',
+    jwt_secret           varchar(255) not null default '',
+    jwt_keys             text         not null default '',
+    jwt_retired_keys     text         not null default '',
+    jwt_signing_key_id   varchar(64)  not null default '',
+    cors_allowed_origins text         not null default '',
+    survey               varchar(255) not null default ''
+);
+
+alter table feedbacks add column tenant varchar(64) not null default '';
+alter table feedbacks add column survey varchar(255) not null default '';
+alter table tokens add column tenant varchar(64) not null default '';
+alter table revocations add column tenant varchar(64) not null default '';
+alter table api_keys add column tenant varchar(64) not null default '';
+alter table audit_log add column tenant varchar(64) not null default '';
+
+CREATE INDEX idx_feedbacks_tenant ON feedbacks(tenant);
+
+-- +goose Down
+drop index idx_feedbacks_tenant;
+alter table audit_log drop column tenant;
+alter table api_keys drop column tenant;
+alter table revocations drop column tenant;
+alter table tokens drop column tenant;
+alter table feedbacks drop column survey;
+alter table feedbacks drop column tenant;
+drop table tenants;
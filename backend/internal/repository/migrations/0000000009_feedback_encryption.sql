@@ -0,0 +1,12 @@
+-- +goose Up
+alter table feedbacks add column data_key text not null default '';
+alter table feedbacks add column key_version varchar(64) not null default '';
+alter table feedbacks alter column rating_comment type text;
+
+CREATE INDEX idx_feedbacks_key_version ON feedbacks(key_version);
+
+-- +goose Down
+drop index idx_feedbacks_key_version;
+alter table feedbacks alter column rating_comment type varchar(1024);
+alter table feedbacks drop column key_version;
+alter table feedbacks drop column data_key;
@@ -0,0 +1,11 @@
+-- +goose Up
+create table privacy_budgets
+(
+    tenant       varchar(64)      not null,
+    window_start timestamp        not null,
+    spent        double precision not null,
+    primary key (tenant, window_start)
+);
+
+-- +goose Down
+drop table privacy_budgets;
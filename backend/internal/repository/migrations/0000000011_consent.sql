@@ -0,0 +1,5 @@
+-- +goose Up
+alter table feedbacks add column consent text[];
+
+-- +goose Down
+alter table feedbacks drop column consent;
@@ -0,0 +1,7 @@
+-- +goose Up
+alter table api_keys add column global boolean not null default false;
+-- keys created before were restricted to a tenant only if they had one
+update api_keys set global = true where tenant = '';
+
+-- +goose Down
+alter table api_keys drop column global;
@@ -0,0 +1,7 @@
+-- +goose Up
+alter table tenants add column admin_oidc_redirect_url varchar(255) not null default '';
+alter table tenants add column admin_oidc_group_roles text not null default '';
+
+-- +goose Down
+alter table tenants drop column admin_oidc_group_roles;
+alter table tenants drop column admin_oidc_redirect_url;
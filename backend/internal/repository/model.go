@@ -18,7 +18,9 @@
 package repository
 
 import (
+	"feedback/internal/ratelimit"
 	"github.com/dariubs/gorm-jsonb"
+	"github.com/lib/pq"
 	"time"
 )
 
@@ -32,5 +34,102 @@ type Feedback struct {
 	Rating        int
 	RatingComment string
 	Metadata      gormjsonb.JSONB
-	Jwt           string `gorm:"index:idx_feedbacks_jwt"`
+	TokenId       string `gorm:"index:idx_feedbacks_token_id"`
+	Anonymous     bool
+	Tenant        string `gorm:"index:idx_feedbacks_tenant"`
+	Survey        string
+	DataKey       string
+	KeyVersion    string `gorm:"index:idx_feedbacks_key_version"`
+	// Consent holds the metadata categories the user consented to. It is null for feedback sent without consent.
+	Consent pq.StringArray `gorm:"type:text[]"`
+}
+
+const (
+	TokenIssued  = "issued"
+	TokenUsed    = "used"
+	TokenRevoked = "revoked"
+)
+
+type Token struct {
+	TokenId   string `gorm:"primaryKey"`
+	Subject   string `gorm:"index:idx_tokens_subject"`
+	MeetingId string
+	State     string
+	IssuedAt  time.Time
+	ExpiresAt time.Time `gorm:"index:idx_tokens_expires_at"`
+	UsedAt    *time.Time
+	Anonymous bool
+	Tenant    string
+}
+
+type Revocation struct {
+	BaseModel
+	TokenId      *string
+	Subject      *string
+	IssuedBefore time.Time
+	ExpiresAt    time.Time `gorm:"index:idx_revocations_expires_at"`
+	Tenant       string
+}
+
+type RateLimitBucket struct {
+	Key string `gorm:"primaryKey"`
+	ratelimit.Bucket
+}
+
+func (RateLimitBucket) TableName() string {
+	return "rate_limits"
+}
+
+// PrivacyBudget is the epsilon a tenant spent on noisy statistics in the window that started at WindowStart.
+type PrivacyBudget struct {
+	Tenant      string    `gorm:"primaryKey"`
+	WindowStart time.Time `gorm:"primaryKey"`
+	Spent       float64
+}
+
+type ApiKey struct {
+	BaseModel
+	Name       string
+	KeyHash    string `gorm:"uniqueIndex:idx_api_keys_key_hash"`
+	KeyPrefix  string
+	Scopes     string
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	Tenant     string
+	// Global keys may act on every tenant, others only on Tenant.
+	Global bool
+}
+
+type AuditEntry struct {
+	BaseModel
+	Sequence     int64
+	Actor        string
+	Action       string
+	Parameters   string
+	AffectedRows int64
+	PreviousHash string
+	Hash         string
+	Tenant       string
+}
+
+func (AuditEntry) TableName() string {
+	return "audit_log"
+}
+
+// Tenant is a tenant kept in the database in addition to the ones of TENANTS_FILE. Hosts and CORS origins are comma
+// separated.
+type Tenant struct {
+	Id                   string `gorm:"primaryKey"`
+	Hosts                string
+	PathPrefix           string
+	MatrixServerName     string
+	OidcValidationUrl    string
+	JwtSecret            string
+	JwtKeys              string
+	JwtRetiredKeys       string
+	JwtSigningKeyId      string
+	CorsAllowedOrigins   string
+	Survey               string
+	AdminOidcRedirectUrl string
+	AdminOidcGroupRoles  string
 }
@@ -0,0 +1,62 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package repository
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+var ErrPrivacyBudgetExhausted = errors.New("privacy budget exhausted")
+
+// SpendPrivacyBudget takes epsilon from the budget of the tenant in the current window, e.g. the current UTC day for a
+// window of 24h. The budget row is locked while it is spent, so that replicas do not overspend it together. It
+// returns the remaining budget and when the next window starts, also if the budget is exhausted.
+func (repo *Repository) SpendPrivacyBudget(tenant string, epsilon float64, budget float64, window time.Duration) (float64, time.Time, error) {
+	now := time.Now().UTC()
+	windowStart := now.Truncate(window)
+	resetAt := windowStart.Add(window)
+
+	var remaining float64
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		row := PrivacyBudget{Tenant: tenant, WindowStart: windowStart}
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&row).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&row, "tenant = ? and window_start = ?", tenant, windowStart).Error
+		if err != nil {
+			return err
+		}
+		remaining = budget - row.Spent
+		if epsilon > remaining {
+			return ErrPrivacyBudgetExhausted
+		}
+		row.Spent += epsilon
+		remaining -= epsilon
+		if err = tx.Save(&row).Error; err != nil {
+			return err
+		}
+		return tx.Where("tenant = ? and window_start < ?", tenant, windowStart).Delete(&PrivacyBudget{}).Error
+	})
+	return remaining, resetAt, err
+}
@@ -0,0 +1,74 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package repository
+
+import (
+	"feedback/internal"
+	"feedback/internal/ratelimit"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sync/atomic"
+	"time"
+)
+
+var lastPurge atomic.Int64
+
+// Allow implements ratelimit.Store on the rate_limits table, so that limits hold across replicas. The bucket row is
+// locked while a token is taken. If the database fails, requests are allowed rather than rejected.
+func (repo *Repository) Allow(key string, limit internal.RateLimit) (bool, time.Duration) {
+	if !limit.Enabled() {
+		return true, 0
+	}
+
+	var allowed bool
+	var retryAfter time.Duration
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		row := RateLimitBucket{Key: key, Bucket: *ratelimit.NewBucket(now, limit)}
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&row).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&row, "key = ?", key).Error
+		if err != nil {
+			return err
+		}
+		allowed, retryAfter = row.Take(now, limit)
+		return tx.Save(&row).Error
+	})
+	if err != nil {
+		log.Errorw("rate limit lookup failed", "error", err)
+		return true, 0
+	}
+
+	repo.purgeFullBuckets()
+	return allowed, retryAfter
+}
+
+// purgeFullBuckets deletes buckets that are full again at most once a minute, as they behave like new ones.
+func (repo *Repository) purgeFullBuckets() {
+	now := time.Now().UnixNano()
+	last := lastPurge.Load()
+	if now-last < int64(time.Minute) || !lastPurge.CompareAndSwap(last, now) {
+		return
+	}
+	if err := repo.db.Where("full_at < ?", time.Now()).Delete(&RateLimitBucket{}).Error; err != nil {
+		log.Errorw("purging rate limits failed", "error", err)
+	}
+}
@@ -18,93 +18,141 @@
 package repository
 
 import (
-	"embed"
+	"context"
 	"errors"
 	"feedback/internal"
+	"feedback/internal/auth"
+	"feedback/internal/encryption"
 	"feedback/internal/logger"
 	_ "github.com/lib/pq"
-	"github.com/pressly/goose/v3"
 	"gorm.io/gorm"
-	"log"
+	"time"
 )
 
-//go:embed migrations/*.sql
-var migrations embed.FS
+var log = logger.Instance()
+
+var (
+	ErrTokenNotFound = errors.New("token is not registered")
+	ErrTokenUsed     = errors.New("token has already been used")
+	ErrTokenRevoked  = errors.New("token has been revoked")
+)
 
 type Interface interface {
+	WithContext(ctx context.Context) Interface
 	Store(value interface{}) error
-	FindByToken(tokenValue string) (Feedback, error)
+	FindByTokenId(tenant string, tokenId string) (Feedback, error)
 	Update(feedbackToUpdate Feedback) (Feedback, error)
+	RegisterToken(token *Token) error
+	FindToken(tenant string, tokenId string) (Token, error)
+	UseToken(tenant string, tokenId string, allowReuse bool) error
+	Transaction(fn func(repo Interface) error) error
+	IsRevoked(tenant string, tokenId string, subject string, issuedAt time.Time) (bool, error)
+	Revoke(revocation *Revocation) (int64, error)
+	CreateApiKey(apiKey *ApiKey) error
+	RevokeApiKey(id uint) error
+	UseApiKey(keyHash string) (*auth.Admin, error)
+	FindFeedbacks(filter FeedbackFilter) ([]Feedback, error)
+	ExportFeedbacks(filter FeedbackFilter, fn func(batch []Feedback) error) error
+	DeleteFeedbacks(filter FeedbackFilter) (int64, error)
+	AppendAudit(entry *AuditEntry) error
+	FindAuditEntries(filter AuditFilter) ([]AuditEntry, error)
+	SpendPrivacyBudget(tenant string, epsilon float64, budget float64, window time.Duration) (float64, time.Time, error)
 }
 
 type Repository struct {
-	config *internal.Configuration
-	db     *gorm.DB
+	config  *internal.Configuration
+	db      *gorm.DB
+	keyring *encryption.Keyring
 }
 
 func New(config *internal.Configuration) *Repository {
-	db, err := createGormDBConnection(config)
+	repo, err := Open(config)
 	if err != nil {
 		panic(err)
 	}
-
-	return &Repository{config, db}
+	return repo
 }
 
-func (repo *Repository) Migrate() {
-	db, err := createPlainSqlConnection(repo.config)
+// Open connects to the database like New, but returns errors instead of panicking, e.g. to report them on the command
+// line.
+func Open(config *internal.Configuration) (*Repository, error) {
+	db, err := createGormDBConnection(config)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
+	}
+	keyring, err := encryption.LoadKeyring(config)
+	if err != nil {
+		return nil, err
 	}
 
-	goose.SetLogger(logger.GooseLoggerWrapper(logger.Instance()))
-	goose.SetBaseFS(migrations)
+	return &Repository{config, db, keyring}, nil
+}
 
-	if err := goose.SetDialect("postgres"); err != nil {
+// Migrate applies all pending migrations and panics if one fails.
+func (repo *Repository) Migrate() {
+	if err := repo.MigrateUp(); err != nil {
 		panic(err)
 	}
+}
 
-	if err := goose.Up(db, "migrations"); err != nil {
-		panic(err)
+// Close closes the connection pool once the last statement has finished.
+func (repo *Repository) Close() error {
+	db, err := repo.db.DB()
+	if err != nil {
+		return err
 	}
+	return db.Close()
 }
 
+// Store creates a row. The comment and identifying metadata of feedback are encrypted if ENCRYPTION_KEYS is set.
 func (repo *Repository) Store(value interface{}) error {
-	tx := repo.db.Create(value)
-	tx.Commit()
-
-	return repo.db.Error
+	if feedback, ok := value.(*Feedback); ok {
+		if err := repo.encryptFeedback(feedback); err != nil {
+			return err
+		}
+	}
+	return repo.db.Create(value).Error
 }
 
-func (repo *Repository) FindByToken(tokenValue string) (Feedback, error) { // rename
+func (repo *Repository) FindByTokenId(tenant string, tokenId string) (Feedback, error) {
 	var feedback = Feedback{}
-	repo.db.Find(&feedback, "Jwt = ?", tokenValue)
-	if feedback.Jwt == "" {
-		return feedback, errors.New("no record with token value found in database")
+	repo.db.Find(&feedback, "tenant = ? AND token_id = ?", tenant, tokenId)
+	if feedback.TokenId == "" {
+		return feedback, errors.New("no record with token id found in database")
 	}
-	return feedback, nil
+	return feedback, repo.decryptFeedback(&feedback)
 }
 
 func (repo *Repository) Update(feedbackToUpdate Feedback) (Feedback, error) {
 
-	if repo.checkIfFeedbackExists(feedbackToUpdate.Jwt) == false {
+	if repo.checkIfFeedbackExists(feedbackToUpdate.Tenant, feedbackToUpdate.TokenId) == false {
 		return Feedback{}, errors.New("no record found for update")
 	}
 
-	fromDatabase, _ := repo.FindByToken(feedbackToUpdate.Jwt)
+	fromDatabase, _ := repo.FindByTokenId(feedbackToUpdate.Tenant, feedbackToUpdate.TokenId)
 
-	repo.db.Model(&fromDatabase).Updates(&Feedback{
-		Rating:        feedbackToUpdate.Rating,
-		RatingComment: feedbackToUpdate.RatingComment,
-		Metadata:      feedbackToUpdate.Metadata,
-	})
+	if err := repo.encryptFeedback(&feedbackToUpdate); err != nil {
+		return Feedback{}, err
+	}
+	// all fields are written, even when empty: the comment and the metadata must always match the new data key, and
+	// the consent of the latest submission applies, even if none was given
+	repo.db.Model(&fromDatabase).
+		Select("rating", "rating_comment", "metadata", "data_key", "key_version", "consent").
+		Updates(&Feedback{
+			Rating:        feedbackToUpdate.Rating,
+			RatingComment: feedbackToUpdate.RatingComment,
+			Metadata:      feedbackToUpdate.Metadata,
+			DataKey:       feedbackToUpdate.DataKey,
+			KeyVersion:    feedbackToUpdate.KeyVersion,
+			Consent:       feedbackToUpdate.Consent,
+		})
 
-	return repo.FindByToken(feedbackToUpdate.Jwt)
+	return repo.FindByTokenId(feedbackToUpdate.Tenant, feedbackToUpdate.TokenId)
 }
 
-func (repo *Repository) checkIfFeedbackExists(tokenValue string) bool {
+func (repo *Repository) checkIfFeedbackExists(tenant string, tokenId string) bool {
 	var feedback = &Feedback{}
-	repo.db.Find(&feedback, "Jwt = ?", tokenValue)
+	repo.db.Find(&feedback, "tenant = ? AND token_id = ?", tenant, tokenId)
 	if feedback.ID == 0 {
 		return false
 	}
@@ -116,3 +164,97 @@ func (repo *Repository) Count() int64 {
 	repo.db.Table("feedbacks").Count(&count)
 	return count
 }
+
+func (repo *Repository) RegisterToken(token *Token) error {
+	return repo.db.Create(token).Error
+}
+
+func (repo *Repository) FindToken(tenant string, tokenId string) (Token, error) {
+	var token = Token{}
+	repo.db.Find(&token, "tenant = ? AND token_id = ?", tenant, tokenId)
+	if token.TokenId == "" {
+		return token, ErrTokenNotFound
+	}
+	return token, nil
+}
+
+// UseToken marks a registered token as used. Tokens that are already used are only accepted again if allowReuse is set.
+func (repo *Repository) UseToken(tenant string, tokenId string, allowReuse bool) error {
+	acceptedStates := []string{TokenIssued}
+	if allowReuse {
+		acceptedStates = append(acceptedStates, TokenUsed)
+	}
+
+	tx := repo.db.Model(&Token{}).
+		Where("tenant = ? AND token_id = ? AND state IN ?", tenant, tokenId, acceptedStates).
+		Updates(map[string]interface{}{"state": TokenUsed, "used_at": time.Now()})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected > 0 {
+		return nil
+	}
+
+	token, err := repo.FindToken(tenant, tokenId)
+	if err != nil {
+		return err
+	}
+	if token.State == TokenRevoked {
+		return ErrTokenRevoked
+	}
+	return ErrTokenUsed
+}
+
+// WithContext returns a repository whose statements run in ctx, so that they are traced as part of the request.
+func (repo *Repository) WithContext(ctx context.Context) Interface {
+	return &Repository{repo.config, repo.db.WithContext(ctx), repo.keyring}
+}
+
+// Transaction runs fn with a repository whose statements are committed together, or rolled back if fn fails.
+func (repo *Repository) Transaction(fn func(repo Interface) error) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&Repository{repo.config, tx, repo.keyring})
+	})
+}
+
+func (repo *Repository) IsRevoked(tenant string, tokenId string, subject string, issuedAt time.Time) (bool, error) {
+	var count int64
+	tx := repo.db.Model(&Revocation{}).
+		Where("tenant = ?", tenant).
+		Where("expires_at > ? AND issued_before > ?", time.Now(), issuedAt).
+		Where("token_id IS NULL OR token_id = ?", tokenId).
+		Where("subject IS NULL OR subject = ?", subject).
+		Count(&count)
+	return count > 0, tx.Error
+}
+
+// Revoke stores the revocation, marks the registered tokens it matches as revoked and purges entries that expired.
+// It returns the number of registered tokens that have been revoked.
+func (repo *Repository) Revoke(revocation *Revocation) (int64, error) {
+	var revoked int64
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(revocation).Error; err != nil {
+			return err
+		}
+
+		tokens := tx.Model(&Token{}).Where("tenant = ? AND state <> ? AND issued_at < ?", revocation.Tenant, TokenRevoked, revocation.IssuedBefore)
+		if revocation.TokenId != nil {
+			tokens = tokens.Where("token_id = ?", *revocation.TokenId)
+		}
+		if revocation.Subject != nil {
+			tokens = tokens.Where("subject = ?", *revocation.Subject)
+		}
+		update := tokens.Update("state", TokenRevoked)
+		if update.Error != nil {
+			return update.Error
+		}
+		revoked = update.RowsAffected
+
+		now := time.Now()
+		if err := tx.Where("expires_at < ?", now).Delete(&Revocation{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("expires_at < ?", now).Delete(&Token{}).Error
+	})
+	return revoked, err
+}
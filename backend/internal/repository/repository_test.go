@@ -21,12 +21,16 @@ import (
 	"context"
 	"errors"
 	"feedback/internal"
+	"feedback/internal/api"
+	"feedback/internal/auth"
 	gormjsonb "github.com/dariubs/gorm-jsonb"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestMain(m *testing.M) {
@@ -69,28 +73,28 @@ func TestMain(m *testing.M) {
 }
 
 func TestRepository_CRU_Roundtrip(t *testing.T) {
-	conf := internal.ConfigurationFromEnv()
+	conf := internal.MustLoadConfiguration()
 	repo := New(conf)
 	repo.Migrate()
 
 	comment := "total doof"
 	rating := 3
 
-	tokenValue := "someJwt"
-	anotherTokenValue := "anotherJwt"
+	tokenId := "someTokenId"
+	anotherTokenId := "anotherTokenId"
 
 	feedback := Feedback{
 		Rating:        rating,
 		RatingComment: comment,
 		Metadata:      gormjsonb.JSONB{"first_key": "first_value", "second_key": "second_value"},
-		Jwt:           tokenValue,
+		TokenId:       tokenId,
 	}
 
 	feedback2 := Feedback{
 		Rating:        rating,
 		RatingComment: comment,
 		Metadata:      gormjsonb.JSONB{"first_key": "first_value", "second_key": "second_value"},
-		Jwt:           anotherTokenValue,
+		TokenId:       anotherTokenId,
 	}
 	// CREATE
 	err := repo.Store(&feedback)
@@ -104,17 +108,17 @@ func TestRepository_CRU_Roundtrip(t *testing.T) {
 	assert.Equal(t, count, int64(2))
 
 	// READ
-	readBeforeUpdate, err := repo.FindByToken(tokenValue)
+	readBeforeUpdate, err := repo.FindByTokenId("", tokenId)
 	assert.Equal(t, readBeforeUpdate.Rating, rating)
 	assert.Equal(t, readBeforeUpdate.RatingComment, comment)
 	assert.Equal(t, readBeforeUpdate.Metadata, gormjsonb.JSONB{"first_key": "first_value", "second_key": "second_value"})
-	assert.Equal(t, readBeforeUpdate.Jwt, tokenValue)
+	assert.Equal(t, readBeforeUpdate.TokenId, tokenId)
 
 	feedbackToUpdate := Feedback{
 		Rating:        -1,
 		RatingComment: comment,
 		Metadata:      gormjsonb.JSONB{"first_key": "first_value", "second_key": "second_value"},
-		Jwt:           tokenValue,
+		TokenId:       tokenId,
 	}
 
 	// UPDATE
@@ -124,35 +128,35 @@ func TestRepository_CRU_Roundtrip(t *testing.T) {
 	}
 
 	// READ
-	readAfterUpdate, err := repo.FindByToken(tokenValue)
+	readAfterUpdate, err := repo.FindByTokenId("", tokenId)
 	assert.Equal(t, readAfterUpdate.Rating, -1)
 	assert.Equal(t, readAfterUpdate.RatingComment, comment)
 	assert.NotNil(t, readAfterUpdate.CreatedAt)
 	assert.Equal(t, readAfterUpdate.Metadata, gormjsonb.JSONB{"first_key": "first_value", "second_key": "second_value"})
-	assert.Equal(t, readAfterUpdate.Jwt, tokenValue)
+	assert.Equal(t, readAfterUpdate.TokenId, tokenId)
 
 	countAfter := repo.Count()
 	assert.Equal(t, countAfter, int64(2))
 
 }
 
-func TestRepository_CRU_Roundtrip_Read_TokenValueNotFound(t *testing.T) {
-	conf := internal.ConfigurationFromEnv()
+func TestRepository_CRU_Roundtrip_Read_TokenIdNotFound(t *testing.T) {
+	conf := internal.MustLoadConfiguration()
 	repo := New(conf)
 	repo.Migrate()
 
 	// READ
-	_, err := repo.FindByToken("tokenValNotAvailable")
+	_, err := repo.FindByTokenId("", "tokenIdNotAvailable")
 
 	if err == nil {
 		// no error occurred, this should not happen.
 		panic(err)
 	}
-	assert.Equal(t, err, errors.New("no record with token value found in database"))
+	assert.Equal(t, err, errors.New("no record with token id found in database"))
 }
 
-func TestRepository_CRU_Roundtrip_Update_TokenValueNotFound(t *testing.T) {
-	conf := internal.ConfigurationFromEnv()
+func TestRepository_CRU_Roundtrip_Update_TokenIdNotFound(t *testing.T) {
+	conf := internal.MustLoadConfiguration()
 	repo := New(conf)
 	repo.Migrate()
 
@@ -167,7 +171,7 @@ func TestRepository_CRU_Roundtrip_Update_TokenValueNotFound(t *testing.T) {
 		Rating:        rating,
 		RatingComment: comment,
 		Metadata:      metadata,
-		Jwt:           "tokenValNotAvailable",
+		TokenId:       "tokenIdNotAvailable",
 	}
 
 	// READ
@@ -179,3 +183,350 @@ func TestRepository_CRU_Roundtrip_Update_TokenValueNotFound(t *testing.T) {
 	}
 	assert.Equal(t, err, errors.New("no record found for update"))
 }
+
+func TestRepository_UseToken(t *testing.T) {
+	conf := internal.MustLoadConfiguration()
+	repo := New(conf)
+	repo.Migrate()
+
+	now := time.Now()
+	singleUse := MapToTokenModel("singleUseTokenId", "someSubject", "", now.Unix(), now.Add(time.Hour).Unix(), false, "")
+	editable := MapToTokenModel("editableTokenId", "someSubject", "", now.Unix(), now.Add(time.Hour).Unix(), false, "")
+	assert.Nil(t, repo.RegisterToken(singleUse))
+	assert.Nil(t, repo.RegisterToken(editable))
+
+	assert.Nil(t, repo.UseToken("", "singleUseTokenId", false))
+	assert.Equal(t, ErrTokenUsed, repo.UseToken("", "singleUseTokenId", false))
+
+	assert.Nil(t, repo.UseToken("", "editableTokenId", true))
+	assert.Nil(t, repo.UseToken("", "editableTokenId", true))
+
+	assert.Equal(t, ErrTokenNotFound, repo.UseToken("", "unknownTokenId", true))
+
+	used, err := repo.FindToken("", "singleUseTokenId")
+	assert.Nil(t, err)
+	assert.Equal(t, TokenUsed, used.State)
+	assert.NotNil(t, used.UsedAt)
+}
+
+func TestRepository_TokensAreScopedToTheirTenant(t *testing.T) {
+	conf := internal.MustLoadConfiguration()
+	repo := New(conf)
+	repo.Migrate()
+
+	now := time.Now()
+	assert.Nil(t, repo.RegisterToken(MapToTokenModel("acmeTokenId", "someSubject", "", now.Unix(), now.Add(time.Hour).Unix(), false, "acme")))
+	assert.Nil(t, repo.Store(MapToFeedbackModel(api.Feedback{Rating: 4}, "acmeTokenId", false, "acme", "")))
+
+	_, err := repo.FindToken("", "acmeTokenId")
+	assert.Equal(t, ErrTokenNotFound, err)
+	assert.Equal(t, ErrTokenNotFound, repo.UseToken("other", "acmeTokenId", true))
+	_, err = repo.FindByTokenId("", "acmeTokenId")
+	assert.NotNil(t, err)
+
+	assert.Nil(t, repo.UseToken("acme", "acmeTokenId", true))
+	feedback, err := repo.FindByTokenId("acme", "acmeTokenId")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, feedback.Rating)
+}
+
+func TestRepository_Transaction_RollsBack(t *testing.T) {
+	conf := internal.MustLoadConfiguration()
+	repo := New(conf)
+	repo.Migrate()
+
+	countBefore := repo.Count()
+	err := repo.Transaction(func(tx Interface) error {
+		if err := tx.Store(&Feedback{Rating: 1, TokenId: "rolledBackTokenId"}); err != nil {
+			return err
+		}
+		return errors.New("abort")
+	})
+
+	assert.Equal(t, errors.New("abort"), err)
+	assert.Equal(t, countBefore, repo.Count())
+}
+
+func TestRepository_Revoke(t *testing.T) {
+	conf := internal.MustLoadConfiguration()
+	repo := New(conf)
+	repo.Migrate()
+
+	issuedAt := time.Now().Add(-time.Minute)
+	token := MapToTokenModel("revokedTokenId", "revokedSubject", "", issuedAt.Unix(), issuedAt.Add(time.Hour).Unix(), false, "")
+	assert.Nil(t, repo.RegisterToken(token))
+
+	revoked, err := repo.IsRevoked("", "revokedTokenId", "revokedSubject", issuedAt)
+	assert.Nil(t, err)
+	assert.False(t, revoked)
+
+	revocation, err := MapToRevocationModel(api.Revocation{Subject: "revokedSubject"}, time.Hour, "")
+	assert.Nil(t, err)
+	count, err := repo.Revoke(revocation)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), count)
+
+	revoked, err = repo.IsRevoked("", "revokedTokenId", "revokedSubject", issuedAt)
+	assert.Nil(t, err)
+	assert.True(t, revoked)
+
+	revoked, err = repo.IsRevoked("", "otherTokenId", "otherSubject", issuedAt)
+	assert.Nil(t, err)
+	assert.False(t, revoked)
+
+	revoked, err = repo.IsRevoked("", "laterTokenId", "revokedSubject", time.Now().Add(time.Minute))
+	assert.Nil(t, err)
+	assert.False(t, revoked)
+
+	assert.Equal(t, ErrTokenRevoked, repo.UseToken("", "revokedTokenId", true))
+}
+
+func TestRepository_ApiKeys(t *testing.T) {
+	conf := internal.MustLoadConfiguration()
+	repo := New(conf)
+	repo.Migrate()
+
+	key, err := auth.NewApiKey()
+	assert.Nil(t, err)
+	apiKey := MapToApiKeyModel("someAdmin", key, []string{auth.ScopeRead, auth.ScopeExport}, "", false)
+	assert.Nil(t, repo.CreateApiKey(apiKey))
+
+	admin, err := repo.UseApiKey(auth.HashApiKey(key))
+	assert.Nil(t, err)
+	assert.Equal(t, "someAdmin", admin.Name)
+	assert.Equal(t, []string{auth.ScopeRead, auth.ScopeExport}, admin.Scopes)
+
+	apiKeys, err := repo.ListApiKeys()
+	assert.Nil(t, err)
+	assert.NotNil(t, apiKeys[len(apiKeys)-1].LastUsedAt)
+
+	assert.Nil(t, repo.RevokeApiKey(apiKey.ID))
+	_, err = repo.UseApiKey(auth.HashApiKey(key))
+	assert.Equal(t, ErrApiKeyNotFound, err)
+	assert.Equal(t, ErrApiKeyNotFound, repo.RevokeApiKey(apiKey.ID))
+}
+
+func TestRepository_FeedbackFilter(t *testing.T) {
+	conf := internal.MustLoadConfiguration()
+	repo := New(conf)
+	repo.Migrate()
+
+	for _, meetingId := range []string{"filteredMeeting", "filteredMeeting", "otherMeeting"} {
+		feedback := api.Feedback{Rating: 4, Metadata: map[string]interface{}{"meetingId": meetingId}}
+		assert.Nil(t, repo.Store(MapToFeedbackModel(feedback, "", false, "", "")))
+	}
+	filter := FeedbackFilter{Metadata: map[string]string{"meetingId": "filteredMeeting"}}
+
+	feedbacks, err := repo.FindFeedbacks(filter)
+	assert.Nil(t, err)
+	assert.Len(t, feedbacks, 2)
+
+	exported := 0
+	err = repo.ExportFeedbacks(filter, func(batch []Feedback) error {
+		exported += len(batch)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, exported)
+
+	_, err = repo.DeleteFeedbacks(FeedbackFilter{})
+	assert.NotNil(t, err)
+	deleted, err := repo.DeleteFeedbacks(filter)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), deleted)
+}
+
+func TestRepository_TenantScoping(t *testing.T) {
+	conf := internal.MustLoadConfiguration()
+	repo := New(conf)
+	repo.Migrate()
+
+	feedback := api.Feedback{Rating: 2, Metadata: map[string]interface{}{"meetingId": "tenantMeeting"}}
+	assert.Nil(t, repo.Store(MapToFeedbackModel(feedback, "", false, "acme", "someSurvey")))
+	filter := FeedbackFilter{Metadata: map[string]string{"meetingId": "tenantMeeting"}}
+
+	feedbacks, err := repo.FindFeedbacks(filter)
+	assert.Nil(t, err)
+	assert.Len(t, feedbacks, 0)
+
+	filter.Tenant = "acme"
+	feedbacks, err = repo.FindFeedbacks(filter)
+	assert.Nil(t, err)
+	assert.Len(t, feedbacks, 1)
+	assert.Equal(t, "someSurvey", feedbacks[0].Survey)
+
+	issuedAt := time.Now().Add(-time.Minute)
+	revocation, err := MapToRevocationModel(api.Revocation{Subject: "tenantSubject"}, time.Hour, "acme")
+	assert.Nil(t, err)
+	_, err = repo.Revoke(revocation)
+	assert.Nil(t, err)
+
+	revoked, err := repo.IsRevoked("acme", "tenantTokenId", "tenantSubject", issuedAt)
+	assert.Nil(t, err)
+	assert.True(t, revoked)
+	revoked, err = repo.IsRevoked("", "tenantTokenId", "tenantSubject", issuedAt)
+	assert.Nil(t, err)
+	assert.False(t, revoked)
+}
+
+func TestRepository_AuditLog(t *testing.T) {
+	conf := internal.MustLoadConfiguration()
+	repo := New(conf)
+	repo.Migrate()
+
+	for _, action := range []string{AuditFeedbackRead, AuditFeedbackExport, AuditFeedbackDelete} {
+		entry, err := MapToAuditEntry("someAdmin", action, map[string]string{"meeting_id": "someMeeting"}, 3, "")
+		assert.Nil(t, err)
+		assert.Nil(t, repo.AppendAudit(entry))
+	}
+
+	count, head, err := repo.VerifyAudit()
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, count, int64(3))
+	entries, err := repo.FindAuditEntriesByHash(head)
+	assert.Nil(t, err)
+	assert.Equal(t, AuditFeedbackDelete, entries[0].Action)
+
+	assert.NotNil(t, repo.db.Exec("UPDATE audit_log SET affected_rows = 0").Error)
+	assert.NotNil(t, repo.db.Exec("DELETE FROM audit_log").Error)
+
+	assert.Nil(t, repo.db.Exec("ALTER TABLE audit_log DISABLE TRIGGER audit_log_append_only").Error)
+	defer repo.db.Exec("ALTER TABLE audit_log ENABLE TRIGGER audit_log_append_only")
+	assert.Nil(t, repo.db.Exec("UPDATE audit_log SET affected_rows = 0 WHERE hash = ?", head).Error)
+
+	_, _, err = repo.VerifyAudit()
+	assert.ErrorIs(t, err, ErrAuditChainBroken)
+}
+
+func TestRepository_FeedbackEncryption(t *testing.T) {
+	conf := internal.MustLoadConfiguration()
+	conf.EncryptionKeys = "v1=base64:MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+	repo := New(conf)
+	repo.Migrate()
+
+	feedback := api.Feedback{Rating: 3, RatingComment: "great, thanks", Metadata: map[string]interface{}{
+		"meetingId": "encryptedMeeting", "displayName": "Some User", "inIframe": true}}
+	assert.Nil(t, repo.Store(MapToFeedbackModel(feedback, "", false, "encrypted", "")))
+
+	var stored Feedback
+	assert.Nil(t, repo.db.Where("tenant = ?", "encrypted").First(&stored).Error)
+	assert.Equal(t, "v1", stored.KeyVersion)
+	assert.NotContains(t, stored.RatingComment, "great")
+	assert.NotEqual(t, "Some User", stored.Metadata["displayName"])
+	assert.Equal(t, "encryptedMeeting", stored.Metadata["meetingId"])
+
+	filter := FeedbackFilter{Tenant: "encrypted", Metadata: map[string]string{"meetingId": "encryptedMeeting"}}
+	feedbacks, err := repo.FindFeedbacks(filter)
+	assert.Nil(t, err)
+	assert.Equal(t, "great, thanks", feedbacks[0].RatingComment)
+	assert.Equal(t, "Some User", feedbacks[0].Metadata["displayName"])
+
+	conf.EncryptionKeys += ",v2=base64:ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="
+	conf.EncryptionActiveKey = "v2"
+	changed, err := New(conf).ReencryptFeedbacks()
+	assert.Nil(t, err)
+	assert.Greater(t, changed, int64(0))
+
+	conf.EncryptionKeys = "v2=base64:ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="
+	feedbacks, err = New(conf).FindFeedbacks(filter)
+	assert.Nil(t, err)
+	assert.Equal(t, "great, thanks", feedbacks[0].RatingComment)
+	assert.Equal(t, true, feedbacks[0].Metadata["inIframe"])
+}
+
+func TestRepository_FeedbackEncryption_UpdateToEmptyComment(t *testing.T) {
+	conf := internal.MustLoadConfiguration()
+	conf.EncryptionKeys = "v1=base64:MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+	repo := New(conf)
+	repo.Migrate()
+
+	feedback := api.Feedback{Rating: 3, RatingComment: "first thoughts", Metadata: map[string]interface{}{
+		"meetingId": "clearedMeeting", "displayName": "Some User"}}
+	stored := MapToFeedbackModel(feedback, "clearedTokenId", false, "", "")
+	assert.Nil(t, repo.Store(stored))
+
+	feedback.RatingComment = ""
+	_, err := repo.Update(*MapToFeedbackModel(feedback, "clearedTokenId", false, "", ""))
+	assert.Nil(t, err)
+
+	updated, err := repo.FindByTokenId("", "clearedTokenId")
+	assert.Nil(t, err)
+	assert.Equal(t, "", updated.RatingComment)
+	assert.Equal(t, "Some User", updated.Metadata["displayName"])
+}
+
+func TestRepository_FeedbackEncryption_OnlyDecryptsEncryptedMetadata(t *testing.T) {
+	conf := internal.MustLoadConfiguration()
+	conf.EncryptionKeys = "v1=base64:MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+	repo := New(conf)
+	repo.Migrate()
+
+	feedback := api.Feedback{Rating: 3, Metadata: map[string]interface{}{
+		"meetingId": "prefixedMeeting", "displayName": "Some User", "browserName": "enc:firefox"}}
+	assert.Nil(t, repo.Store(MapToFeedbackModel(feedback, "prefixedTokenId", false, "", "")))
+
+	read, err := repo.FindByTokenId("", "prefixedTokenId")
+	assert.Nil(t, err)
+	assert.Equal(t, "Some User", read.Metadata["displayName"])
+	assert.Equal(t, "enc:firefox", read.Metadata["browserName"])
+}
+
+func TestRepository_SpendPrivacyBudget(t *testing.T) {
+	conf := internal.MustLoadConfiguration()
+	repo := New(conf)
+	repo.Migrate()
+
+	remaining, resetAt, err := repo.SpendPrivacyBudget("budgetTenant", 0.75, 1, time.Hour)
+	assert.Nil(t, err)
+	assert.InDelta(t, 0.25, remaining, 1e-9)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), resetAt, time.Hour)
+
+	remaining, _, err = repo.SpendPrivacyBudget("budgetTenant", 0.5, 1, time.Hour)
+	assert.Equal(t, ErrPrivacyBudgetExhausted, err)
+	assert.InDelta(t, 0.25, remaining, 1e-9)
+
+	remaining, _, err = repo.SpendPrivacyBudget("otherBudgetTenant", 0.5, 1, time.Hour)
+	assert.Nil(t, err)
+	assert.InDelta(t, 0.5, remaining, 1e-9)
+}
+
+func TestRepository_ConsentFilter(t *testing.T) {
+	conf := internal.MustLoadConfiguration()
+	repo := New(conf)
+	repo.Migrate()
+
+	meeting := map[string]interface{}{"meetingId": "consentMeeting"}
+	for _, given := range []*api.Consent{nil, {}, {Categories: []string{"identity", "technical"}}} {
+		feedback := api.Feedback{Rating: 3, Metadata: meeting, Consent: given}
+		assert.Nil(t, repo.Store(MapToFeedbackModel(feedback, "", false, "", "")))
+	}
+	filter := FeedbackFilter{Metadata: map[string]string{"meetingId": "consentMeeting"}}
+
+	filter.Consent = "identity"
+	feedbacks, err := repo.FindFeedbacks(filter)
+	assert.Nil(t, err)
+	assert.Len(t, feedbacks, 1)
+	assert.Equal(t, &api.Consent{Categories: []string{"identity", "technical"}}, MapToStoredFeedback(feedbacks[0]).Consent)
+
+	filter.Consent, filter.NoConsent = "", "identity"
+	feedbacks, err = repo.FindFeedbacks(filter)
+	assert.Nil(t, err)
+	assert.Len(t, feedbacks, 2)
+	assert.Nil(t, feedbacks[0].Consent)
+	assert.Equal(t, pq.StringArray{}, feedbacks[1].Consent)
+}
+
+func TestRepository_MigrationStates(t *testing.T) {
+	conf := internal.MustLoadConfiguration()
+	repo, err := Open(conf)
+	assert.Nil(t, err)
+	assert.Nil(t, repo.MigrateUp())
+
+	states, err := repo.MigrationStates()
+	assert.Nil(t, err)
+	assert.NotEmpty(t, states)
+	assert.Equal(t, MigrationState{1, "0000000001_initial.sql", true}, states[0])
+	for _, state := range states {
+		assert.True(t, state.Applied, state.Source)
+	}
+}
@@ -0,0 +1,37 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package repository
+
+import "feedback/internal/tenant"
+
+// ListTenants implements tenant.Store.
+func (repo *Repository) ListTenants() ([]tenant.Tenant, error) {
+	var dbTenants []Tenant
+	if err := repo.db.Order("id").Find(&dbTenants).Error; err != nil {
+		return nil, err
+	}
+	tenants := make([]tenant.Tenant, 0, len(dbTenants))
+	for _, dbTenant := range dbTenants {
+		mapped, err := MapToTenant(dbTenant)
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, mapped)
+	}
+	return tenants, nil
+}
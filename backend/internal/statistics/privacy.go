@@ -0,0 +1,100 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package statistics
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Noise added by a Mechanism
+const (
+	NoiseLaplace  = "laplace"
+	NoiseGaussian = "gaussian"
+)
+
+// Ratings are clamped to the range Jitsi sends, -1 for no rating up to five stars, when noise is added. This bounds how
+// much a single feedback can change the sum of ratings to MaxRating.
+const (
+	MinRating = -1
+	MaxRating = 5
+)
+
+// Mechanism makes aggregates differentially private by adding noise calibrated to epsilon and, for Gaussian noise,
+// delta. Every feedback is counted in exactly one bucket, so a query spends epsilon once however many buckets it has.
+type Mechanism struct {
+	Noise   string
+	Epsilon float64
+	Delta   float64
+	random  func() float64
+}
+
+func NewMechanism(noise string, epsilon float64, delta float64) (*Mechanism, error) {
+	if noise != NoiseLaplace && noise != NoiseGaussian {
+		return nil, fmt.Errorf("noise is neither %s nor %s: %s", NoiseLaplace, NoiseGaussian, noise)
+	}
+	if !(epsilon > 0) || math.IsInf(epsilon, 0) {
+		return nil, fmt.Errorf("epsilon is not a positive number: %g", epsilon)
+	}
+	if noise == NoiseGaussian && (epsilon > 1 || !(delta > 0) || delta >= 1) {
+		return nil, errors.New("gaussian noise requires an epsilon of at most 1 and a delta between 0 and 1")
+	}
+	if noise == NoiseLaplace {
+		delta = 0
+	}
+	return &Mechanism{noise, epsilon, delta, uniform}, nil
+}
+
+// perturb adds noise to a value that a single feedback changes by at most sensitivity. Counts and rating sums each
+// spend half of epsilon and delta.
+func (mechanism *Mechanism) perturb(value float64, sensitivity float64) float64 {
+	epsilon := mechanism.Epsilon / 2
+	if mechanism.Noise == NoiseGaussian {
+		delta := mechanism.Delta / 2
+		sigma := sensitivity * math.Sqrt(2*math.Log(1.25/delta)) / epsilon
+		// Box-Muller transform, 1-u avoids the logarithm of 0
+		u, v := mechanism.random(), mechanism.random()
+		return value + sigma*math.Sqrt(-2*math.Log(1-u))*math.Cos(2*math.Pi*v)
+	}
+
+	// the difference of two exponentially distributed numbers is Laplace distributed
+	scale := sensitivity / epsilon
+	return value + scale*(math.Log(1-mechanism.random())-math.Log(1-mechanism.random()))
+}
+
+// uniform returns a random number in [0, 1) from a cryptographic source, as predictable noise could be subtracted.
+func uniform() float64 {
+	var bytes [8]byte
+	if _, err := rand.Read(bytes[:]); err != nil {
+		panic(err)
+	}
+	return float64(binary.BigEndian.Uint64(bytes[:])>>11) / (1 << 53)
+}
+
+func clampRating(rating int) int {
+	if rating < MinRating {
+		return MinRating
+	}
+	if rating > MaxRating {
+		return MaxRating
+	}
+	return rating
+}
@@ -0,0 +1,173 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package statistics
+
+import (
+	"encoding/json"
+	"feedback/internal/api"
+	"math"
+	"sort"
+)
+
+// How buckets below the minimum group size are reported
+const (
+	SmallGroupsMerge    = "merge"
+	SmallGroupsSuppress = "suppress"
+)
+
+// Aggregator counts feedback in buckets of equal values of the grouped metadata keys.
+type Aggregator struct {
+	groupBy   []string
+	buckets   map[string]*bucket
+	mechanism *Mechanism
+}
+
+type bucket struct {
+	group     map[string]interface{}
+	count     int
+	ratingSum float64
+}
+
+func NewAggregator(groupBy []string) *Aggregator {
+	return &Aggregator{groupBy, map[string]*bucket{}, nil}
+}
+
+// AddNoise makes the buckets differentially private with the mechanism. It is called before any feedback is added,
+// as ratings are clamped when they are added. Which groups exist is not protected, so only metadata keys with publicly
+// known values should be grouped by.
+func (aggregator *Aggregator) AddNoise(mechanism *Mechanism) {
+	aggregator.mechanism = mechanism
+}
+
+// Add counts a feedback. Missing metadata keys form a group of their own.
+func (aggregator *Aggregator) Add(rating int, metadata map[string]interface{}) {
+	key := aggregator.groupKey(metadata)
+	current, found := aggregator.buckets[key]
+	if !found {
+		current = &bucket{group: map[string]interface{}{}}
+		for _, name := range aggregator.groupBy {
+			current.group[name] = metadata[name]
+		}
+		aggregator.buckets[key] = current
+	}
+	if aggregator.mechanism != nil {
+		rating = clampRating(rating)
+	}
+	current.count++
+	current.ratingSum += float64(rating)
+}
+
+// Size returns the number of feedbacks counted with the same values of the grouped keys as metadata.
+func (aggregator *Aggregator) Size(metadata map[string]interface{}) int {
+	if current, found := aggregator.buckets[aggregator.groupKey(metadata)]; found {
+		return current.count
+	}
+	return 0
+}
+
+// Count returns the number of feedbacks counted.
+func (aggregator *Aggregator) Count() int {
+	count := 0
+	for _, current := range aggregator.buckets {
+		count += current.count
+	}
+	return count
+}
+
+// Buckets returns the buckets ordered by their group. Buckets of fewer than minGroupSize feedbacks are either
+// suppressed or merged into a last bucket, which is suppressed in turn if it is still too small. With noise, every
+// call perturbs the buckets anew and the group sizes are compared after perturbation.
+func (aggregator *Aggregator) Buckets(minGroupSize int, smallGroups string) []api.StatisticsBucket {
+	keys := make([]string, 0, len(aggregator.buckets))
+	for key := range aggregator.buckets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	buckets := []api.StatisticsBucket{}
+	merged := &bucket{}
+	mergedGroups := 0
+	for _, key := range keys {
+		current := aggregator.buckets[key]
+		if aggregator.mechanism != nil {
+			current = current.perturb(aggregator.mechanism)
+		}
+		switch {
+		case current.count >= minGroupSize:
+			buckets = append(buckets, current.report(false))
+		case smallGroups == SmallGroupsSuppress:
+			buckets = append(buckets, api.StatisticsBucket{Group: current.group, Suppressed: true})
+		default:
+			merged.count += current.count
+			merged.ratingSum += current.ratingSum
+			mergedGroups++
+		}
+	}
+
+	if mergedGroups > 0 {
+		report := merged.report(merged.count < minGroupSize)
+		report.MergedGroups = mergedGroups
+		buckets = append(buckets, report)
+	}
+	return buckets
+}
+
+// perturb returns a copy of the bucket with noisy count and rating sum. The average rating of the noisy values is
+// kept within the range of ratings.
+func (current *bucket) perturb(mechanism *Mechanism) *bucket {
+	count := math.Round(mechanism.perturb(float64(current.count), 1))
+	if count < 1 {
+		return &bucket{group: current.group}
+	}
+	ratingSum := mechanism.perturb(current.ratingSum, MaxRating)
+	ratingSum = math.Max(count*MinRating, math.Min(count*MaxRating, ratingSum))
+	return &bucket{current.group, int(count), ratingSum}
+}
+
+func (current *bucket) report(suppressed bool) api.StatisticsBucket {
+	if suppressed || current.count == 0 {
+		return api.StatisticsBucket{Group: current.group, Suppressed: true}
+	}
+	return api.StatisticsBucket{
+		Group:         current.group,
+		Count:         current.count,
+		AverageRating: current.ratingSum / float64(current.count),
+	}
+}
+
+// groupKey encodes the values of the grouped keys, which may be of any JSON type.
+func (aggregator *Aggregator) groupKey(metadata map[string]interface{}) string {
+	values := make([]interface{}, len(aggregator.groupBy))
+	for i, name := range aggregator.groupBy {
+		values[i] = metadata[name]
+	}
+	key, _ := json.Marshal(values)
+	return string(key)
+}
+
+// Suppress returns a copy of metadata without the given keys.
+func Suppress(metadata map[string]interface{}, keys []string) map[string]interface{} {
+	suppressed := make(map[string]interface{}, len(metadata))
+	for key, value := range metadata {
+		suppressed[key] = value
+	}
+	for _, key := range keys {
+		delete(suppressed, key)
+	}
+	return suppressed
+}
@@ -0,0 +1,74 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package statistics
+
+import (
+	"feedback/internal/api"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestAggregator_MergedBucketBelowMinimumIsSuppressed(t *testing.T) {
+	aggregator := NewAggregator([]string{"meetingId"})
+	for _, meetingId := range []string{"a", "a", "a", "b"} {
+		aggregator.Add(5, map[string]interface{}{"meetingId": meetingId})
+	}
+	aggregator.Add(1, map[string]interface{}{})
+
+	assert.Equal(t, []api.StatisticsBucket{
+		{Group: map[string]interface{}{"meetingId": "a"}, Count: 3, AverageRating: 5},
+		{Suppressed: true, MergedGroups: 2},
+	}, aggregator.Buckets(3, SmallGroupsMerge))
+	assert.Equal(t, 3, aggregator.Size(map[string]interface{}{"meetingId": "a", "appShard": "any"}))
+	assert.Equal(t, 1, aggregator.Size(map[string]interface{}{"meetingId": nil}))
+	assert.Equal(t, 0, aggregator.Size(map[string]interface{}{"meetingId": "d"}))
+}
+
+func TestAggregator_AddNoise(t *testing.T) {
+	mechanism, err := NewMechanism(NoiseLaplace, 1, 0)
+	assert.Nil(t, err)
+	aggregator := NewAggregator(nil)
+	aggregator.AddNoise(mechanism)
+	for i := 0; i < 1000; i++ {
+		aggregator.Add(4, nil)
+	}
+	aggregator.Add(100, nil)
+
+	// the noise of a count with sensitivity 1 at epsilon 0.5 exceeds 50 with a probability of e^-25
+	bucket := aggregator.Buckets(1, SmallGroupsMerge)[0]
+	assert.InDelta(t, 1001, bucket.Count, 50)
+	assert.InDelta(t, 4, bucket.AverageRating, 0.5)
+
+	mechanism.random = func() float64 { return 0.5 }
+	assert.Equal(t, 10.0, mechanism.perturb(10, 1))
+}
+
+func TestNewMechanism(t *testing.T) {
+	for _, invalid := range []struct {
+		noise   string
+		epsilon float64
+		delta   float64
+	}{{"uniform", 1, 0}, {NoiseLaplace, 0, 0}, {NoiseGaussian, 2, 1e-6}, {NoiseGaussian, 0.5, 0}} {
+		_, err := NewMechanism(invalid.noise, invalid.epsilon, invalid.delta)
+		assert.NotNil(t, err, invalid)
+	}
+
+	mechanism, err := NewMechanism(NoiseGaussian, 0.5, 1e-6)
+	assert.Nil(t, err)
+	assert.Equal(t, 1e-6, mechanism.Delta)
+}
@@ -0,0 +1,167 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package tenant
+
+import (
+	"context"
+	"encoding/json"
+	"feedback/internal/logger"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RefreshInterval is how long tenants read from the database are cached.
+const RefreshInterval = time.Minute
+
+var log = logger.Instance()
+
+// Store provides the tenants kept in the database.
+type Store interface {
+	ListTenants() ([]Tenant, error)
+}
+
+// Registry resolves requests to the tenants of TENANTS_FILE and, if a store is given, the database.
+type Registry struct {
+	static   []Tenant
+	store    Store
+	mutex    sync.Mutex
+	tenants  []Tenant
+	loadedAt time.Time
+}
+
+func NewRegistry(static []Tenant, store Store) *Registry {
+	return &Registry{static: static, store: store}
+}
+
+// LoadFile reads a JSON array of tenants.
+func LoadFile(path string) ([]Tenant, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tenants []Tenant
+	if err = json.Unmarshal(content, &tenants); err != nil {
+		return nil, fmt.Errorf("tenants file %s: %w", path, err)
+	}
+	return tenants, Validate(tenants)
+}
+
+// Validate checks that every tenant has a unique ID and can be told apart from the others by host or path prefix.
+func Validate(tenants []Tenant) error {
+	ids := map[string]bool{}
+	hosts := map[string]bool{}
+	prefixes := map[string]bool{}
+	for _, tenant := range tenants {
+		if tenant.Id == "" || ids[tenant.Id] {
+			return fmt.Errorf("tenant id %q is empty or not unique", tenant.Id)
+		}
+		ids[tenant.Id] = true
+		if len(tenant.Hosts) == 0 && tenant.PathPrefix == "" {
+			return fmt.Errorf("tenant %s has neither hosts nor a path prefix", tenant.Id)
+		}
+		for _, host := range tenant.Hosts {
+			host = strings.ToLower(host)
+			if hosts[host] {
+				return fmt.Errorf("host %s is used by more than one tenant", host)
+			}
+			hosts[host] = true
+		}
+		if tenant.PathPrefix != "" {
+			if !strings.HasPrefix(tenant.PathPrefix, "/") || strings.HasSuffix(tenant.PathPrefix, "/") {
+				return fmt.Errorf("path prefix %s of tenant %s must start but not end with a slash", tenant.PathPrefix, tenant.Id)
+			}
+			if prefixes[tenant.PathPrefix] {
+				return fmt.Errorf("path prefix %s is used by more than one tenant", tenant.PathPrefix)
+			}
+			prefixes[tenant.PathPrefix] = true
+		}
+	}
+	return nil
+}
+
+// Tenants returns all tenants. If the database can't be read or holds invalid tenants, the previous ones are kept.
+func (registry *Registry) Tenants() []Tenant {
+	if registry.store == nil {
+		return registry.static
+	}
+
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	now := time.Now()
+	if registry.tenants != nil && now.Sub(registry.loadedAt) < RefreshInterval {
+		return registry.tenants
+	}
+	registry.loadedAt = now
+
+	stored, err := registry.store.ListTenants()
+	if err == nil {
+		tenants := append(append([]Tenant{}, registry.static...), stored...)
+		if err = Validate(tenants); err == nil {
+			registry.tenants = tenants
+		}
+	}
+	if err != nil {
+		log.Errorw("loading tenants failed", "error", err)
+		if registry.tenants == nil {
+			registry.tenants = registry.static
+		}
+	}
+	return registry.tenants
+}
+
+// Resolve adds the tenant a request is addressed to to its context, matched by host first and path prefix second.
+// The prefix is stripped from the path, so the routes are the same for every tenant. Requests that match no tenant
+// are returned unchanged and belong to the default tenant.
+func (registry *Registry) Resolve(request *http.Request) *http.Request {
+	tenants := registry.Tenants()
+
+	host := strings.ToLower(request.Host)
+	if withoutPort, _, err := net.SplitHostPort(host); err == nil {
+		host = withoutPort
+	}
+	for i := range tenants {
+		for _, tenantHost := range tenants[i].Hosts {
+			if strings.ToLower(tenantHost) == host {
+				return request.WithContext(WithTenant(request.Context(), &tenants[i]))
+			}
+		}
+	}
+
+	path := request.URL.Path
+	for i := range tenants {
+		prefix := tenants[i].PathPrefix
+		if prefix == "" || path != prefix && !strings.HasPrefix(path, prefix+"/") {
+			continue
+		}
+		resolved := request.WithContext(context.WithValue(WithTenant(request.Context(), &tenants[i]), prefixKey{}, prefix))
+		url := *request.URL
+		url.Path = strings.TrimPrefix(path, prefix)
+		url.RawPath = ""
+		if url.Path == "" {
+			url.Path = "/"
+		}
+		resolved.URL = &url
+		return resolved
+	}
+	return request
+}
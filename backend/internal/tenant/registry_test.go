@@ -0,0 +1,97 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package tenant
+
+import (
+	"errors"
+	"feedback/internal"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"testing"
+)
+
+type storeStub struct {
+	tenants []Tenant
+	err     error
+}
+
+func (store *storeStub) ListTenants() ([]Tenant, error) {
+	return store.tenants, store.err
+}
+
+func TestRegistry_Resolve(t *testing.T) {
+	registry := NewRegistry([]Tenant{
+		{Id: "acme", Hosts: []string{"Feedback.Acme.Example"}},
+		{Id: "globex", PathPrefix: "/globex"},
+	}, nil)
+
+	for target, expected := range map[string]struct {
+		tenantId string
+		path     string
+	}{
+		"http://feedback.acme.example:8080/feedback": {"acme", "/feedback"},
+		"http://feedback.acme.example/globex/token":  {"acme", "/globex/token"},
+		"/globex/token/anonymous":                    {"globex", "/token/anonymous"},
+		"/globex":                                    {"globex", "/"},
+		"/globexcorp/token":                          {"", "/globexcorp/token"},
+		"/feedback":                                  {"", "/feedback"},
+	} {
+		resolved := registry.Resolve(httptest.NewRequest("GET", target, nil))
+		assert.Equal(t, expected.tenantId, IdFrom(resolved.Context()), target)
+		assert.Equal(t, expected.path, resolved.URL.Path, target)
+	}
+}
+
+func TestRegistry_KeepsTenantsIfStoreFails(t *testing.T) {
+	store := &storeStub{tenants: []Tenant{{Id: "acme", PathPrefix: "/acme"}}}
+	registry := NewRegistry([]Tenant{{Id: "globex", PathPrefix: "/globex"}}, store)
+	assert.Len(t, registry.Tenants(), 2)
+
+	store.err = errors.New("database is down")
+	registry.loadedAt = registry.loadedAt.Add(-RefreshInterval)
+	assert.Len(t, registry.Tenants(), 2)
+
+	store.tenants, store.err = []Tenant{{Id: "globex", PathPrefix: "/other"}}, nil
+	registry.loadedAt = registry.loadedAt.Add(-RefreshInterval)
+	assert.Len(t, registry.Tenants(), 2, "duplicate IDs are rejected")
+}
+
+func TestValidate(t *testing.T) {
+	assert.Nil(t, Validate([]Tenant{{Id: "acme", Hosts: []string{"acme.example"}}, {Id: "globex", PathPrefix: "/globex"}}))
+	assert.NotNil(t, Validate([]Tenant{{Hosts: []string{"acme.example"}}}))
+	assert.NotNil(t, Validate([]Tenant{{Id: "acme"}}))
+	assert.NotNil(t, Validate([]Tenant{{Id: "acme", PathPrefix: "/acme/"}}))
+	assert.NotNil(t, Validate([]Tenant{{Id: "acme", Hosts: []string{"a.example"}}, {Id: "globex", Hosts: []string{"A.example"}}}))
+}
+
+func TestTenant_Apply(t *testing.T) {
+	config := &internal.Configuration{MatrixServerName: "domain.tld", JwtSecret: "someSecret", CorsAllowedOrigins: []string{"*"}}
+
+	assert.Same(t, config, (*Tenant)(nil).Apply(config))
+
+	applied := (&Tenant{Id: "acme", MatrixServerName: "acme.example", JwtKeys: "acme=acme.pem"}).Apply(config)
+	assert.Equal(t, "acme", applied.TenantId)
+	assert.Equal(t, "acme.example", applied.MatrixServerName)
+	assert.Equal(t, "", applied.JwtSecret)
+	assert.Equal(t, "acme=acme.pem", applied.JwtKeys)
+	assert.Equal(t, []string{"*"}, applied.CorsAllowedOrigins)
+	assert.Equal(t, "domain.tld", config.MatrixServerName)
+
+	applied = (&Tenant{Id: "acme", AdminOidcGroupRoles: map[string]string{"acme-admins": "admin"}}).Apply(config)
+	assert.Equal(t, map[string]string{"acme-admins": "admin"}, applied.AdminOidcGroupRoles)
+}
@@ -0,0 +1,107 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package tenant
+
+import (
+	"context"
+	"feedback/internal"
+)
+
+// Tenant is a Matrix homeserver and Jitsi deployment served by the same backend. Fields left empty fall back to the
+// global configuration.
+type Tenant struct {
+	Id                 string   `json:"id"`
+	Hosts              []string `json:"hosts"`
+	PathPrefix         string   `json:"path_prefix"`
+	MatrixServerName   string   `json:"matrix_server_name"`
+	OidcValidationUrl  string   `json:"oidc_validation_url"`
+	JwtSecret          string   `json:"jwt_secret"`
+	JwtKeys            string   `json:"jwt_keys"`
+	JwtRetiredKeys     string   `json:"jwt_retired_keys"`
+	JwtSigningKeyId    string   `json:"jwt_signing_key_id"`
+	CorsAllowedOrigins []string `json:"cors_allowed_origins"`
+	Survey             string   `json:"survey"`
+	// AdminOidcRedirectUrl is where the identity provider returns administrators of the tenant to. If empty, it is
+	// derived from ADMIN_OIDC_REDIRECT_URL and the host or path prefix the tenant was resolved by.
+	AdminOidcRedirectUrl string            `json:"admin_oidc_redirect_url"`
+	AdminOidcGroupRoles  map[string]string `json:"admin_oidc_group_roles"`
+}
+
+type contextKey struct{}
+
+type prefixKey struct{}
+
+func WithTenant(ctx context.Context, tenant *Tenant) context.Context {
+	return context.WithValue(ctx, contextKey{}, tenant)
+}
+
+// From returns the tenant a request has been resolved to, or nil for the default tenant.
+func From(ctx context.Context) *Tenant {
+	tenant, _ := ctx.Value(contextKey{}).(*Tenant)
+	return tenant
+}
+
+// PathPrefixFrom returns the path prefix stripped from a request resolved to its tenant by the prefix, or an empty
+// string for requests resolved by host or belonging to the default tenant.
+func PathPrefixFrom(ctx context.Context) string {
+	prefix, _ := ctx.Value(prefixKey{}).(string)
+	return prefix
+}
+
+// IdFrom returns the ID of the tenant a request has been resolved to, which is empty for the default tenant.
+func IdFrom(ctx context.Context) string {
+	if tenant := From(ctx); tenant != nil {
+		return tenant.Id
+	}
+	return ""
+}
+
+// Apply returns a copy of config with the settings of the tenant. Signing keys are only taken over as a whole, so a
+// tenant never accepts tokens signed with the keys of the default tenant.
+func (tenant *Tenant) Apply(config *internal.Configuration) *internal.Configuration {
+	if tenant == nil {
+		return config
+	}
+	applied := *config
+	applied.TenantId = tenant.Id
+	if tenant.MatrixServerName != "" {
+		applied.MatrixServerName = tenant.MatrixServerName
+	}
+	if tenant.OidcValidationUrl != "" {
+		applied.OidcValidationUrl = tenant.OidcValidationUrl
+	}
+	if tenant.JwtSecret != "" || tenant.JwtKeys != "" {
+		applied.JwtSecret = tenant.JwtSecret
+		applied.JwtKeys = tenant.JwtKeys
+		applied.JwtRetiredKeys = tenant.JwtRetiredKeys
+		applied.JwtSigningKeyId = tenant.JwtSigningKeyId
+	}
+	if len(tenant.CorsAllowedOrigins) > 0 {
+		applied.CorsAllowedOrigins = tenant.CorsAllowedOrigins
+	}
+	if tenant.Survey != "" {
+		applied.Survey = tenant.Survey
+	}
+	if tenant.AdminOidcRedirectUrl != "" {
+		applied.AdminOidcRedirectUrl = tenant.AdminOidcRedirectUrl
+	}
+	if len(tenant.AdminOidcGroupRoles) > 0 {
+		applied.AdminOidcGroupRoles = tenant.AdminOidcGroupRoles
+	}
+	return &applied
+}
@@ -0,0 +1,192 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"feedback/internal"
+	"feedback/internal/logger"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// CheckInterval limits how often the certificate files are checked for changes.
+const CheckInterval = 10 * time.Second
+
+var log = logger.Instance()
+
+var versions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// New returns the TLS configuration of TLS_CERT_FILE and TLS_KEY_FILE. The files are reloaded when they change on
+// disk, e.g. after a renewal, without dropping connections. With TLS_CLIENT_CA_FILE, clients may authenticate with a
+// certificate issued by one of its CAs, which is reloaded the same way.
+func New(config *internal.Configuration) (*tls.Config, error) {
+	minVersion, found := versions[config.TlsMinVersion]
+	if !found {
+		return nil, fmt.Errorf("TLS version %s is neither 1.2 nor 1.3", config.TlsMinVersion)
+	}
+	cipherSuites, err := cipherSuites(config.TlsCipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	files := &reloader{certFile: config.TlsCertFile, keyFile: config.TlsKeyFile, caFile: config.TlsClientCaFile}
+	if err := files.load(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:   minVersion,
+		CipherSuites: cipherSuites,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			certificate, _ := files.current()
+			return certificate, nil
+		},
+	}
+	if config.TlsClientCaFile != "" {
+		// certificates are optional, as only admins authenticate with them
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			forClient := tlsConfig.Clone()
+			_, forClient.ClientCAs = files.current()
+			return forClient, nil
+		}
+	}
+	return tlsConfig, nil
+}
+
+// cipherSuites looks up cipher suites by their names, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Only suites without
+// known weaknesses are accepted. Without names, Go's defaults apply.
+func cipherSuites(names []string) ([]uint16, error) {
+	var ids []uint16
+	for _, name := range names {
+		id, found := uint16(0), false
+		for _, suite := range tls.CipherSuites() {
+			if suite.Name == name {
+				id, found = suite.ID, true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("cipher suite %s is unknown or insecure", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// reloader holds the certificate and client CAs last read from disk.
+type reloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mutex       sync.Mutex
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+	modified    time.Time
+	checked     time.Time
+}
+
+// current returns the certificate and client CAs, after reloading them if the files have changed since they were read.
+// If they can't be reloaded, the ones read before remain in use.
+func (files *reloader) current() (*tls.Certificate, *x509.CertPool) {
+	files.mutex.Lock()
+	defer files.mutex.Unlock()
+
+	if time.Since(files.checked) >= CheckInterval {
+		files.checked = time.Now()
+		if modified, err := files.lastModified(); err == nil && !modified.Equal(files.modified) {
+			if err := files.load(); err != nil {
+				log.Warnw("failed to reload TLS certificate", "file", files.certFile, "error", err)
+			} else {
+				log.Infow("reloaded TLS certificate", "file", files.certFile)
+			}
+		}
+	}
+	return files.certificate, files.clientCAs
+}
+
+func (files *reloader) load() error {
+	modified, err := files.lastModified()
+	if err != nil {
+		return err
+	}
+	certificate, err := tls.LoadX509KeyPair(files.certFile, files.keyFile)
+	if err != nil {
+		return err
+	}
+
+	var clientCAs *x509.CertPool
+	if files.caFile != "" {
+		content, err := os.ReadFile(files.caFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(content) {
+			return errors.New("client CA file " + files.caFile + " contains no certificates")
+		}
+	}
+
+	files.certificate, files.clientCAs = &certificate, clientCAs
+	files.modified, files.checked = modified, time.Now()
+	return nil
+}
+
+// lastModified returns the latest modification time of the files. It is compared for equality, so that files
+// replaced by an older version, e.g. when a mounted secret is rolled back, are reloaded as well.
+func (files *reloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{files.certFile, files.keyFile, files.caFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// Redirect answers plain HTTP requests with a permanent redirect to the same URL served with TLS on listenAddress.
+func Redirect(listenAddress string) http.Handler {
+	_, port, _ := net.SplitHostPort(listenAddress)
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		host := request.Host
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(writer, request, "https://"+host+request.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,128 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"feedback/internal"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCertificate(t *testing.T, directory string, commonName string, modified time.Time) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, _ := x509.MarshalPKCS8PrivateKey(key)
+	for name, block := range map[string]*pem.Block{
+		"tls.crt": {Type: "CERTIFICATE", Bytes: der},
+		"tls.key": {Type: "PRIVATE KEY", Bytes: keyDer},
+	} {
+		path := filepath.Join(directory, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func commonName(t *testing.T, certificate *tls.Certificate) string {
+	parsed, err := x509.ParseCertificate(certificate.Certificate[0])
+	assert.Nil(t, err)
+	return parsed.Subject.CommonName
+}
+
+func TestNew(t *testing.T) {
+	directory := t.TempDir()
+	writeCertificate(t, directory, "feedback", time.Now())
+
+	tlsConfig, err := New(&internal.Configuration{
+		TlsCertFile:   filepath.Join(directory, "tls.crt"),
+		TlsKeyFile:    filepath.Join(directory, "tls.key"),
+		TlsMinVersion: "1.3",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), tlsConfig.MinVersion)
+	certificate, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{})
+	assert.Nil(t, err)
+	assert.Equal(t, "feedback", commonName(t, certificate))
+
+	_, err = New(&internal.Configuration{TlsMinVersion: "1.1"})
+	assert.EqualError(t, err, "TLS version 1.1 is neither 1.2 nor 1.3")
+}
+
+func TestReloader_ReloadsChangedFiles(t *testing.T) {
+	directory := t.TempDir()
+	writeCertificate(t, directory, "first", time.Now().Add(-time.Minute))
+	files := &reloader{certFile: filepath.Join(directory, "tls.crt"), keyFile: filepath.Join(directory, "tls.key")}
+	assert.Nil(t, files.load())
+
+	writeCertificate(t, directory, "second", time.Now())
+	certificate, _ := files.current()
+	assert.Equal(t, "first", commonName(t, certificate), "files are checked at most once per interval")
+
+	files.checked = time.Now().Add(-CheckInterval)
+	certificate, _ = files.current()
+	assert.Equal(t, "second", commonName(t, certificate))
+
+	assert.Nil(t, os.WriteFile(files.keyFile, []byte("broken"), 0600))
+	files.checked = time.Now().Add(-CheckInterval)
+	certificate, _ = files.current()
+	assert.Equal(t, "second", commonName(t, certificate), "the previous certificate remains in use")
+}
+
+func TestNew_RejectsInsecureCipherSuites(t *testing.T) {
+	_, err := cipherSuites([]string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"})
+	assert.Nil(t, err)
+	_, err = cipherSuites([]string{"TLS_RSA_WITH_RC4_128_SHA"})
+	assert.EqualError(t, err, "cipher suite TLS_RSA_WITH_RC4_128_SHA is unknown or insecure")
+}
+
+func TestRedirect(t *testing.T) {
+	for listenAddress, expected := range map[string]string{
+		":8443": "https://feedback.example.com:8443/token?meeting_id=1",
+		":443":  "https://feedback.example.com/token?meeting_id=1",
+	} {
+		recorder := httptest.NewRecorder()
+		Redirect(listenAddress).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://feedback.example.com:8080/token?meeting_id=1", nil))
+		assert.Equal(t, http.StatusPermanentRedirect, recorder.Code)
+		assert.Equal(t, expected, recorder.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,87 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package tracing
+
+import (
+	"context"
+	"feedback/internal"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+const ServiceName = "feedback-backend"
+
+// Setup installs the W3C trace context propagator and, with OTEL_TRACES_EXPORTER=otlp, a tracer provider that exports
+// spans via OTLP/HTTP. The exporter, sampler and resource are further configured by the standard OTEL_* variables.
+// Without an exporter, spans are not recorded, but trace context is still passed on to the user verification service.
+func Setup(ctx context.Context, config *internal.Configuration) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if config.TracesExporter == internal.TracesExporterNone {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence over the default service name
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceNameKey.String(ServiceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK())
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Handler starts a server span for every request, continuing the trace of the caller if it sent a traceparent header.
+func Handler(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, ServiceName)
+}
+
+// Route names the server span after the route template, e.g. "GET /admin/feedback", once mux has matched the request.
+func Route(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if current := mux.CurrentRoute(request); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				span := trace.SpanFromContext(request.Context())
+				span.SetName(request.Method + " " + template)
+				span.SetAttributes(semconv.HTTPRouteKey.String(template))
+			}
+		}
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// Transport starts a client span for every outgoing request and sends the trace context along.
+func Transport(name string, base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(base, otelhttp.WithSpanNameFormatter(func(_ string, request *http.Request) string {
+		return name + " " + request.Method
+	}))
+}
@@ -0,0 +1,67 @@
+/*
+ *  Copyright 2022 Nordeck IT + Consulting GmbH
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and  limitations
+ *  under the License.
+ *
+ */
+
+package tracing
+
+import (
+	"context"
+	"feedback/internal"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTracing_ContinuesTraceOfCallerAndPassesItOn(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	_, err := Setup(context.Background(), &internal.Configuration{TracesExporter: internal.TracesExporterNone})
+	assert.Nil(t, err)
+
+	var forwarded string
+	upstream := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		forwarded = request.Header.Get("traceparent")
+	}))
+	defer upstream.Close()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/feedback/{id}", func(writer http.ResponseWriter, request *http.Request) {
+		outgoing, _ := http.NewRequestWithContext(request.Context(), http.MethodPost, upstream.URL, nil)
+		response, err := (&http.Client{Transport: Transport("UVS", http.DefaultTransport)}).Do(outgoing)
+		assert.Nil(t, err)
+		response.Body.Close()
+	})
+	router.Use(Route)
+
+	request := httptest.NewRequest(http.MethodGet, "/feedback/42", nil)
+	request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	Handler(router).ServeHTTP(httptest.NewRecorder(), request)
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 2)
+	client, server := spans[0], spans[1]
+	assert.Equal(t, "GET /feedback/{id}", server.Name())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+	assert.Equal(t, "UVS POST", client.Name())
+	assert.Equal(t, server.SpanContext().SpanID(), client.Parent().SpanID())
+	assert.Contains(t, forwarded, "4bf92f3577b34da6a3ce929d0e0e4736-"+client.SpanContext().SpanID().String())
+}
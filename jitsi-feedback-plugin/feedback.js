@@ -51,7 +51,10 @@
             console.log(`${LOG} Feedback`, rating, comment);
 
             const jwt = window.APP.conference.feedbackToken;
-            console.log(`${LOG} gather metrics for  JWT: ${jwt}`);
+            if (!jwt) {
+                console.log(`${LOG} no feedback JWT, feedback is not sent`);
+                return;
+            }
         
             const postFeedback = async (jwt, payload) => {
                 const baseUrl = APP.store.getState()['features/base/config'].feedbackBackend;
@@ -99,14 +102,11 @@
         handleJoin() {
             this.enableFeedbackOnLeave();
 
-            // Extract matrix openId token from the Jitsi JWT token
-            const oidToken = this._getMatrixContext().matrix.token;
-
-            console.log(`${LOG} Extracted matrix token: ${oidToken}`);
+            const baseUrl = APP.store.getState()['features/base/config'].feedbackBackend;
+            const meetingId = JitsiMeetJS.analytics.permanentProperties.conference_name;
 
             const getToken = async (oidToken) => {
-                const baseUrl = APP.store.getState()['features/base/config'].feedbackBackend;
-                const url = `${baseUrl}/token`;
+                const url = `${baseUrl}/token?meeting_id=${encodeURIComponent(meetingId)}`;
                 
                 const headers = {
                     'authorization': `Bearer ${oidToken}`
@@ -124,10 +124,45 @@
                 return res.text();
             };
 
+            // guests without a Matrix account solve a proof-of-work challenge instead
+            const getAnonymousToken = async () => {
+                const challengeRes = await fetch(`${baseUrl}/token/anonymous/challenge`);
+                if (!challengeRes.ok) {
+                    throw `${LOG}  Status error: ${challengeRes.status}`;
+                }
+                const {challenge, difficulty} = await challengeRes.json();
+                const nonce = await this._solveChallenge(challenge, difficulty);
+
+                const res = await fetch(`${baseUrl}/token/anonymous`, {
+                    method: 'POST',
+                    body: JSON.stringify({challenge, nonce, meeting_id: meetingId})
+                });
+
+                if (!res.ok) {
+                    throw `${LOG}  Status error: ${res.status}`;
+                }
+
+                return res.text();
+            };
+
+            // Extract matrix openId token from the Jitsi JWT token
+            const oidToken = this._getMatrixToken();
+            const anonymous = APP.store.getState()['features/base/config'].feedbackAnonymous;
+
+            let tokenRequest;
+            if (oidToken) {
+                tokenRequest = getToken(oidToken);
+            } else if (anonymous) {
+                tokenRequest = getAnonymousToken();
+            } else {
+                console.log(`${LOG} no matrix token, feedback disabled`);
+                return;
+            }
+
             // get the feedback JWT token as soon as possible
-            getToken(oidToken)
+            tokenRequest
                 .then(feedbackToken => {
-                    console.log(`${LOG} feedback JWT: ${feedbackToken}`);
+                    console.log(`${LOG} received feedback JWT`);
                     window.APP.conference.feedbackToken = feedbackToken;
                 })
                 .catch(e => console.error(`${LOG} failed to fetch JWT`, e));
@@ -135,6 +170,25 @@
             return;
         }
 
+        async _solveChallenge(challenge, difficulty) {
+            const encoder = new TextEncoder();
+            for (let nonce = 0; ; nonce++) {
+                const digest = new Uint8Array(await crypto.subtle.digest('SHA-256', encoder.encode(challenge + nonce)));
+                let zeroBits = 0;
+                for (const byte of digest) {
+                    if (byte === 0) {
+                        zeroBits += 8;
+                        continue;
+                    }
+                    zeroBits += Math.clz32(byte) - 24;
+                    break;
+                }
+                if (zeroBits >= difficulty) {
+                    return String(nonce);
+                }
+            }
+        }
+
         _gatherMetrics() {
             const metrics = {};
             const config = APP.store.getState()['features/base/config'];
@@ -158,6 +212,14 @@
             return content.context;
         }
 
+        _getMatrixToken() {
+            try {
+                return this._getMatrixContext().matrix.token;
+            } catch (e) {
+                return undefined;
+            }
+        }
+
         _addMetric(flag, metrics)  {
             const config = APP.store.getState()['features/base/config'];
             const conference = window.APP.store.getState()['features/base/conference'].conference;